@@ -0,0 +1,272 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors returned when an API key can't be used, use errors.Is to check for them
+var (
+	ErrAPIKeyMalformed = errors.New("malformed api key")
+	ErrAPIKeyNotFound  = errors.New("api key not found")
+	ErrAPIKeyExpired   = errors.New("api key has expired")
+	ErrAPIKeyRevoked   = errors.New("api key has been revoked")
+)
+
+//APIKey is an API key as kept in a KeyStore. Keys have the form "<prefix>.<secret>", the prefix identifies the key and is stored in clear text while only the SHA-256 hash of the full key is stored.
+type APIKey struct {
+	Prefix    string            `json:"prefix"`
+	Hash      string            `json:"sha256"` // hex encoded SHA-256 hash of the full key
+	Owner     string            `json:"owner"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	ExpiresAt time.Time         `json:"expires_at"` // zero if the key never expires
+	Revoked   bool              `json:"revoked,omitempty"`
+}
+
+//GenerateAPIKey returns a new random key belonging to owner, and the APIKey to store for it. The key itself isn't stored anywhere, so it must be handed to the client right away.
+func GenerateAPIKey(owner string) (key string, k *APIKey, err error) {
+	b := make([]byte, 38)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	prefix := base64.RawURLEncoding.EncodeToString(b[:6])
+	key = prefix + "." + base64.RawURLEncoding.EncodeToString(b[6:])
+	return key, &APIKey{Prefix: prefix, Hash: HashAPIKey(key), Owner: owner}, nil
+}
+
+//HashAPIKey returns the hex encoded SHA-256 hash of key, as stored in APIKey.Hash
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// clone returns a copy of k that doesn't share its metadata
+func (k *APIKey) clone() *APIKey {
+	c := *k
+	if k.Metadata != nil {
+		c.Metadata = make(map[string]string, len(k.Metadata))
+		for n, v := range k.Metadata {
+			c.Metadata[n] = v
+		}
+	}
+	return &c
+}
+
+//KeyStore looks up API keys by their prefix. LookupKey returns ErrAPIKeyNotFound if there's no key with prefix.
+type KeyStore interface {
+	LookupKey(ctx context.Context, prefix string) (*APIKey, error)
+}
+
+//MemoryKeyStore is a KeyStore holding its keys in memory, it's safe for concurrent use
+type MemoryKeyStore struct {
+	mu   sync.RWMutex
+	keys map[string]*APIKey
+}
+
+//NewMemoryKeyStore returns a MemoryKeyStore holding keys, or an error if two keys share a prefix
+func NewMemoryKeyStore(keys ...*APIKey) (*MemoryKeyStore, error) {
+	s := &MemoryKeyStore{keys: map[string]*APIKey{}}
+	for _, k := range keys {
+		if err := s.Add(k); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+//Add stores k, or returns an error if a key with the same prefix is already stored
+func (s *MemoryKeyStore) Add(k *APIKey) error {
+	if k.Prefix == "" || k.Hash == "" {
+		return errors.New("api key requires a prefix and a hash")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[k.Prefix]; ok {
+		return fmt.Errorf("duplicate api key prefix %q", k.Prefix)
+	}
+	s.keys[k.Prefix] = k.clone()
+	return nil
+}
+
+//Revoke marks the key with prefix as revoked
+func (s *MemoryKeyStore) Revoke(prefix string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[prefix]
+	if !ok {
+		return ErrAPIKeyNotFound
+	}
+	c := k.clone()
+	c.Revoked = true
+	s.keys[prefix] = c
+	return nil
+}
+
+//LookupKey returns a copy of the key with prefix, so changing it doesn't change the store
+func (s *MemoryKeyStore) LookupKey(ctx context.Context, prefix string) (*APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if k, ok := s.keys[prefix]; ok {
+		return k.clone(), nil
+	}
+	return nil, ErrAPIKeyNotFound
+}
+
+//FileKeyStore is a KeyStore backed by a JSON file holding an array of APIKey objects. The file is reloaded when it changes on disk.
+type FileKeyStore struct {
+	file *watchedFile
+}
+
+//NewFileKeyStore returns a FileKeyStore reading keys from path, or an error if the file can't be read or is malformed
+func NewFileKeyStore(path string) (*FileKeyStore, error) {
+	f, err := newWatchedFile(path, func(r io.Reader) (interface{}, error) {
+		var list []*APIKey
+		if err := json.NewDecoder(r).Decode(&list); err != nil {
+			return nil, fmt.Errorf("malformed key file %s: %w", path, err)
+		}
+		keys, err := NewMemoryKeyStore(list...)
+		if err != nil {
+			return nil, fmt.Errorf("malformed key file %s: %w", path, err)
+		}
+		return keys, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &FileKeyStore{file: f}, nil
+}
+
+//LookupKey returns the key with prefix
+func (s *FileKeyStore) LookupKey(ctx context.Context, prefix string) (*APIKey, error) {
+	return s.file.load().(*MemoryKeyStore).LookupKey(ctx, prefix)
+}
+
+//APIKeyOptions holds the settings used to build an APIKeyValidator
+type APIKeyOptions struct {
+	Store      KeyStore         // looks up the keys, required
+	Extractors []TokenExtractor // where to find the key, defaults to the X-API-Key header
+	Realm      string           // realm sent in the WWW-Authenticate challenge
+
+	// ErrorResponder answers rejected requests, defaults to DefaultErrorResponder. err matches ErrAPIKeyExpired or ErrAPIKeyRevoked for known keys that can't be used anymore, e.g. to log them.
+	ErrorResponder ErrorResponder
+}
+
+//APIKeyValidator authenticates requests using static API keys
+type APIKeyValidator struct {
+	store   KeyStore
+	reader  *TokenReader
+	realm   string
+	respond ErrorResponder
+	now     func() time.Time
+}
+
+//NewAPIKeyValidator returns an APIKeyValidator using the settings in opts, or an error if no KeyStore is given
+func NewAPIKeyValidator(opts APIKeyOptions) (*APIKeyValidator, error) {
+	if opts.Store == nil {
+		return nil, errors.New("api key validator requires a KeyStore")
+	}
+	extractors := opts.Extractors
+	if len(extractors) == 0 {
+		extractors = []TokenExtractor{HeaderToken("X-API-Key", "")}
+	}
+	reader, err := NewTokenReader(TokenOptions{Extractors: extractors})
+	if err != nil {
+		return nil, err
+	}
+	v := &APIKeyValidator{store: opts.Store, reader: reader, realm: opts.Realm, respond: opts.ErrorResponder, now: time.Now}
+	if v.respond == nil {
+		v.respond = DefaultErrorResponder
+	}
+	return v, nil
+}
+
+//Verify looks key up in the store and returns it if it's valid, i.e. known, not expired and not revoked
+func (v *APIKeyValidator) Verify(ctx context.Context, key string) (*APIKey, error) {
+	prefix, _, ok := strings.Cut(key, ".")
+	if !ok || prefix == "" {
+		return nil, ErrAPIKeyMalformed
+	}
+	k, err := v.store.LookupKey(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(HashAPIKey(key)), []byte(strings.ToLower(k.Hash))) != 1 {
+		return nil, ErrAPIKeyNotFound
+	}
+	if k.Revoked {
+		return nil, ErrAPIKeyRevoked
+	}
+	if !k.ExpiresAt.IsZero() && !v.now().Before(k.ExpiresAt) {
+		return nil, ErrAPIKeyExpired
+	}
+	return k, nil
+}
+
+//Handler returns a http.Handler that verifies the API key of the request before calling next. On success the key is available through APIKeyFrom and its owner through PrincipalFrom, with the key's prefix and metadata as attributes. Requests without a valid key are answered by the ErrorResponder, with 401 Unauthorized by default.
+func (v *APIKeyValidator) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, err := v.reader.Extract(r)
+		if errors.Is(err, ErrMultipleTokens) {
+			v.respond(w, r, http.StatusBadRequest, "", err)
+			return
+		}
+		if err != nil {
+			v.respond(w, r, http.StatusUnauthorized, v.challenge(), err)
+			return
+		}
+		k, err := v.Verify(r.Context(), key)
+		if err != nil {
+			if !errors.Is(err, ErrAPIKeyNotFound) && !errors.Is(err, ErrAPIKeyMalformed) {
+				err = fmt.Errorf("%s: %w", apiKeyPrefix(key), err)
+			}
+			v.respond(w, r, http.StatusUnauthorized, v.challenge(), err)
+			return
+		}
+		ctx := context.WithValue(r.Context(), apiKeyContextKey, k)
+		ctx = withPrincipal(ctx, k.principal())
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (v *APIKeyValidator) challenge() string {
+	c := "APIKey"
+	if v.realm != "" {
+		c += " realm=" + quoteParam(v.realm)
+	}
+	return c
+}
+
+// apiKeyPrefix returns the prefix of key, so that a key can be identified without revealing it
+func apiKeyPrefix(key string) string {
+	prefix, _, _ := strings.Cut(key, ".")
+	return prefix
+}
+
+func (k *APIKey) principal() *Identity {
+	attrs := map[string]string{}
+	for n, v := range k.Metadata {
+		attrs[n] = v
+	}
+	attrs["key_prefix"] = k.Prefix
+	return &Identity{Name: k.Owner, Method: AuthMethodAPIKey, Attrs: attrs}
+}
+
+//APIKeyFrom returns the API key verified by an APIKeyValidator, if no key is found it returns an error
+func APIKeyFrom(ctx context.Context) (*APIKey, error) {
+	if k, ok := ctx.Value(apiKeyContextKey).(*APIKey); ok {
+		return k, nil
+	}
+	return nil, errors.New("no api key found in context")
+}
@@ -0,0 +1,233 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	key, k, err := GenerateAPIKey("billing")
+	assert.Nil(t, err, "should be nil")
+	assert.True(t, strings.HasPrefix(key, k.Prefix+"."), "should be true")
+	assert.Equal(t, HashAPIKey(key), k.Hash, "should be equal")
+	assert.False(t, strings.Contains(k.Hash, key), "should be false")
+	assert.Equal(t, "billing", k.Owner, "should be equal")
+}
+
+func TestAPIKeyValidator(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	key, k, err := GenerateAPIKey("billing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Metadata = map[string]string{"env": "prod"}
+	expiredKey, expired, _ := GenerateAPIKey("reports")
+	expired.ExpiresAt = now.Add(-time.Minute)
+	revokedKey, revoked, _ := GenerateAPIKey("legacy")
+
+	store, err := NewMemoryKeyStore(k, expired, revoked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, store.Revoke(revoked.Prefix), "should be nil")
+
+	v, err := NewAPIKeyValidator(APIKeyOptions{Store: store, Realm: "api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.now = func() time.Time { return now }
+
+	t.Run("Verify", func(t *testing.T) {
+		_, err := v.Verify(context.Background(), key)
+		assert.Nil(t, err, "should be nil")
+
+		for candidate, want := range map[string]error{
+			expiredKey:                ErrAPIKeyExpired,
+			revokedKey:                ErrAPIKeyRevoked,
+			k.Prefix + ".wrongsecret": ErrAPIKeyNotFound,
+			"unknown.secret":          ErrAPIKeyNotFound,
+			"nosecretseparator":       ErrAPIKeyMalformed,
+		} {
+			_, err := v.Verify(context.Background(), candidate)
+			assert.True(t, errors.Is(err, want), "should be true: %v", err)
+		}
+	})
+
+	t.Run("Handler", func(t *testing.T) {
+		var b bytes.Buffer
+		SetOutput(&b)
+		h := v.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ak, err := APIKeyFrom(r.Context())
+			assert.Nil(t, err, "should be nil")
+			assert.Equal(t, k.Prefix, ak.Prefix, "should be equal")
+			p, err := PrincipalFrom(r.Context())
+			assert.Nil(t, err, "should be nil")
+			assert.Equal(t, "billing", p.Subject(), "should be equal")
+			assert.Equal(t, AuthMethodAPIKey, p.AuthMethod(), "should be equal")
+			assert.Equal(t, "prod", p.Attributes()["env"], "should be equal")
+			assert.Equal(t, k.Prefix, p.Attributes()["key_prefix"], "should be equal")
+		}))
+
+		for candidate, status := range map[string]int{key: http.StatusOK, expiredKey: http.StatusUnauthorized, revokedKey: http.StatusUnauthorized, "": http.StatusUnauthorized} {
+			req, err := http.NewRequest("GET", "/invoices", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if candidate != "" {
+				req.Header.Set("X-API-Key", candidate)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			assert.Equal(t, status, rr.Code, "should be equal")
+			if status == http.StatusUnauthorized {
+				assert.Equal(t, `APIKey realm="api"`, rr.Header().Get("WWW-Authenticate"), "should be equal")
+			}
+		}
+		assert.Empty(t, b.String(), "should not write to the output")
+	})
+
+	t.Run("ErrorResponder", func(t *testing.T) {
+		var rejected []error
+		v, err := NewAPIKeyValidator(APIKeyOptions{Store: store, ErrorResponder: func(w http.ResponseWriter, r *http.Request, status int, challenge string, err error) {
+			rejected = append(rejected, err)
+			DefaultErrorResponder(w, r, status, challenge, err)
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		v.now = func() time.Time { return now }
+		req, err := http.NewRequest("GET", "/invoices", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-API-Key", revokedKey)
+		rr := httptest.NewRecorder()
+		v.Handler(http.NotFoundHandler()).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code, "should be equal")
+		if assert.Len(t, rejected, 1, "should have length 1") {
+			assert.True(t, errors.Is(rejected[0], ErrAPIKeyRevoked), "should be true")
+			assert.Contains(t, rejected[0].Error(), revoked.Prefix, "should identify the key")
+		}
+	})
+
+	t.Run("Stored keys can't be changed", func(t *testing.T) {
+		meta := map[string]string{"plan": "pro"}
+		_, ck, _ := GenerateAPIKey("shop")
+		ck.Metadata = meta
+		s, err := NewMemoryKeyStore(ck)
+		if err != nil {
+			t.Fatal(err)
+		}
+		meta["plan"] = "free"
+
+		found, err := s.LookupKey(context.Background(), ck.Prefix)
+		assert.Nil(t, err, "should be nil")
+		assert.Equal(t, "pro", found.Metadata["plan"], "should be equal")
+		found.Revoked = true
+		found.Metadata["plan"] = "enterprise"
+
+		found, err = s.LookupKey(context.Background(), ck.Prefix)
+		assert.Nil(t, err, "should be nil")
+		assert.False(t, found.Revoked, "should be false")
+		assert.Equal(t, "pro", found.Metadata["plan"], "should be equal")
+
+		assert.Nil(t, s.Revoke(ck.Prefix), "should be nil")
+		found, _ = s.LookupKey(context.Background(), ck.Prefix)
+		assert.True(t, found.Revoked, "should be true")
+		assert.Equal(t, "pro", found.Metadata["plan"], "should be equal")
+	})
+
+	t.Run("Requires store", func(t *testing.T) {
+		_, err := NewAPIKeyValidator(APIKeyOptions{})
+		assert.NotNil(t, err, "should not be nil")
+	})
+
+	t.Run("Duplicate prefix", func(t *testing.T) {
+		_, err := NewMemoryKeyStore(k, k)
+		assert.NotNil(t, err, "should not be nil")
+	})
+}
+
+func TestFileKeyStore(t *testing.T) {
+	key, k, err := GenerateAPIKey("billing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "keys.json")
+	write := func(keys ...*APIKey) {
+		b, err := json.Marshal(keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, b, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(k)
+
+	s, err := NewFileKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewAPIKeyValidator(APIKeyOptions{Store: s})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = v.Verify(context.Background(), key)
+	assert.Nil(t, err, "should be nil")
+
+	t.Run("Reloads on change", func(t *testing.T) {
+		r := *k
+		r.Revoked = true
+		write(&r)
+		later := time.Now().Add(time.Minute)
+		os.Chtimes(path, later, later)
+		s.file.lastCheck = time.Time{}
+
+		_, err := v.Verify(context.Background(), key)
+		assert.True(t, errors.Is(err, ErrAPIKeyRevoked), "should be true")
+	})
+
+	t.Run("Malformed file", func(t *testing.T) {
+		bad := filepath.Join(t.TempDir(), "keys.json")
+		os.WriteFile(bad, []byte("{"), 0600)
+		_, err := NewFileKeyStore(bad)
+		assert.NotNil(t, err, "should not be nil")
+	})
+}
+
+func ExampleAPIKeyValidator() {
+	store, err := NewFileKeyStore("/etc/myapp/keys.json")
+	if err != nil {
+		// error handling
+	}
+	v, err := NewAPIKeyValidator(APIKeyOptions{
+		Store:      store,
+		Extractors: []TokenExtractor{HeaderToken("X-API-Token", ""), HeaderToken("Authorization", "Bearer")},
+	})
+	if err != nil {
+		// error handling
+	}
+
+	defaultHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		k, err := APIKeyFrom(r.Context())
+		if err != nil {
+			// error handling
+		}
+		_ = k.Metadata["plan"]
+	})
+
+	http.Handle("/", v.Handler(defaultHandler))
+	http.ListenAndServe(":3000", nil)
+}
@@ -0,0 +1,114 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+//ChainScheme is an authentication scheme tried by an AuthChain
+type ChainScheme struct {
+	Name    string                          // recorded in the context when the scheme authenticates a request, e.g. "basic"
+	Handler func(http.Handler) http.Handler // authenticating middleware, e.g. the Handler method of a BasicValidator or JWTValidator
+}
+
+//AuthChainOptions holds the settings used to build an AuthChain
+type AuthChainOptions struct {
+	Schemes        []ChainScheme  // tried in order, at least one is required
+	ErrorResponder ErrorResponder // answers requests rejected by every scheme, defaults to DefaultErrorResponder
+}
+
+//AuthChain authenticates requests using the first of several schemes that accepts them, e.g. Basic for legacy clients and Bearer tokens for everyone else
+type AuthChain struct {
+	schemes []ChainScheme
+	respond ErrorResponder
+}
+
+//NewAuthChain returns an AuthChain using the settings in opts, or an error if no schemes are given or a scheme lacks a name or handler
+func NewAuthChain(opts AuthChainOptions) (*AuthChain, error) {
+	if len(opts.Schemes) == 0 {
+		return nil, errors.New("auth chain requires at least one scheme")
+	}
+	for i, s := range opts.Schemes {
+		if s.Name == "" || s.Handler == nil {
+			return nil, fmt.Errorf("auth chain scheme %d requires a name and a handler", i)
+		}
+	}
+	c := &AuthChain{schemes: opts.Schemes, respond: opts.ErrorResponder}
+	if c.respond == nil {
+		c.respond = DefaultErrorResponder
+	}
+	return c, nil
+}
+
+//Handler returns a http.Handler that tries each scheme in order and calls next with the context of the first one that accepts the request. The name of that scheme is available through AuthScheme.
+//
+// A scheme rejecting the request with 401 Unauthorized passes it on to the next scheme, any other response, e.g. 503 Service Unavailable from an unreachable Introspector, is sent to the client right away. If every scheme rejects the request, the WWW-Authenticate challenges of all schemes are combined in a single 401 Unauthorized response.
+func (c *AuthChain) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var challenges []string
+		for _, s := range c.schemes {
+			rec := &schemeRecorder{header: http.Header{}}
+			var passed bool
+			name := s.Name
+			s.Handler(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				passed = true
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authSchemeContextKey, name)))
+			})).ServeHTTP(rec, r)
+			if passed {
+				return
+			}
+			if rec.status != http.StatusUnauthorized {
+				rec.replay(w)
+				return
+			}
+			challenges = append(challenges, rec.header.Values("WWW-Authenticate")...)
+		}
+		c.respond(w, r, http.StatusUnauthorized, strings.Join(challenges, ", "), errors.New("no scheme accepted the request"))
+	})
+}
+
+//AuthScheme returns the name of the ChainScheme that authenticated the request, if no AuthChain has authenticated it an error is returned
+func AuthScheme(ctx context.Context) (string, error) {
+	if s, ok := ctx.Value(authSchemeContextKey).(string); ok {
+		return s, nil
+	}
+	return "", errors.New("no auth scheme found in context")
+}
+
+// schemeRecorder holds the response of a scheme that rejected a request, so it can be dropped in favour of the next scheme
+type schemeRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (s *schemeRecorder) Header() http.Header {
+	return s.header
+}
+
+func (s *schemeRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+}
+
+func (s *schemeRecorder) Write(b []byte) (int, error) {
+	s.WriteHeader(http.StatusOK)
+	return s.body.Write(b)
+}
+
+// replay sends the recorded response to w
+func (s *schemeRecorder) replay(w http.ResponseWriter) {
+	for k, v := range s.header {
+		w.Header()[k] = v
+	}
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	w.WriteHeader(s.status)
+	w.Write(s.body.Bytes())
+}
@@ -0,0 +1,47 @@
+package middlewares
+
+import (
+	"fmt"
+	"net/http"
+)
+
+//AuthMode controls how TokenReader and BasicExtractor treat requests with missing or malformed credentials
+type AuthMode int
+
+const (
+	AuthOptional AuthMode = iota // a warning is written to the output set with SetOutput and the request is passed on, this is the default
+	AuthRequired                 // the request is answered by the ErrorResponder, with 401 Unauthorized and a WWW-Authenticate challenge by default
+	AuthSilent                   // the request is passed on without a warning
+)
+
+func (m AuthMode) validate() error {
+	if m < AuthOptional || m > AuthSilent {
+		return fmt.Errorf("unknown auth mode %d", m)
+	}
+	return nil
+}
+
+//ErrorResponder writes the response to a request rejected by an authentication handler. status is the status code the handler would respond with, challenge the WWW-Authenticate challenge to send (if any) and err the reason the request was rejected.
+type ErrorResponder func(w http.ResponseWriter, r *http.Request, status int, challenge string, err error)
+
+//DefaultErrorResponder sets the WWW-Authenticate header to challenge, unless it's empty, and answers with status and its status text. err isn't revealed to the client.
+func DefaultErrorResponder(w http.ResponseWriter, r *http.Request, status int, challenge string, err error) {
+	if challenge != "" {
+		w.Header().Set("WWW-Authenticate", challenge)
+	}
+	http.Error(w, http.StatusText(status), status)
+}
+
+// authFailure handles a request whose credentials couldn't be extracted according to mode. It reports whether the request has been answered, if not the caller should pass it on.
+func authFailure(mode AuthMode, respond ErrorResponder, w http.ResponseWriter, r *http.Request, status int, challenge string, err error) bool {
+	switch mode {
+	case AuthRequired:
+		respond(w, r, status, challenge, err)
+		return true
+	case AuthSilent:
+		return false
+	}
+	fmt.Fprintf(output, "warn: %s\n", err.Error())
+	return false
+}
+
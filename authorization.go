@@ -0,0 +1,133 @@
+package middlewares
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Errors passed to the ErrorResponder of an Authorizer, use errors.Is to check for them
+var (
+	ErrNotAuthenticated  = errors.New("request has no verified principal")
+	ErrInsufficientScope = errors.New("principal lacks a required scope")
+	ErrMissingRole       = errors.New("principal lacks a required role")
+)
+
+//AuthorizationOptions holds the settings used to build an Authorizer
+type AuthorizationOptions struct {
+	Challenge      string         // WWW-Authenticate challenge sent with 401 Unauthorized, e.g. `Basic realm="admin"` behind a BasicValidator, defaults to "Bearer"
+	ErrorResponder ErrorResponder // answers rejected requests, defaults to DefaultErrorResponder
+}
+
+//Authorizer builds middlewares that check the scopes and roles of the authenticated principal. Use it instead of RequireScopes and RequireRoles to send another challenge than Bearer, or to answer rejected requests differently.
+type Authorizer struct {
+	challenge string
+	respond   ErrorResponder
+}
+
+var defaultAuthorizer = &Authorizer{challenge: "Bearer", respond: DefaultErrorResponder}
+
+//NewAuthorizer returns an Authorizer using the settings in opts
+func NewAuthorizer(opts AuthorizationOptions) (*Authorizer, error) {
+	a := &Authorizer{challenge: opts.Challenge, respond: opts.ErrorResponder}
+	if a.challenge == "" {
+		a.challenge = defaultAuthorizer.challenge
+	}
+	if a.respond == nil {
+		a.respond = DefaultErrorResponder
+	}
+	return a, nil
+}
+
+//RequireScopes returns a middleware that only calls next if the authenticated principal has been granted all of scopes. It must be chained after an authentication handler that stores a Principal in the context, e.g. a JWTValidator or Introspector.
+//
+// Requests without a verified principal are answered with 401 Unauthorized and a Bearer challenge, requests lacking a scope with 403 Forbidden and a RFC 6750 insufficient_scope challenge. Use an Authorizer to send another challenge.
+func RequireScopes(scopes ...string) func(http.Handler) http.Handler {
+	return defaultAuthorizer.RequireScopes(scopes...)
+}
+
+//RequireAnyScope works like RequireScopes, but only requires one of scopes to be granted
+func RequireAnyScope(scopes ...string) func(http.Handler) http.Handler {
+	return defaultAuthorizer.RequireAnyScope(scopes...)
+}
+
+//RequireRoles returns a middleware that only calls next if the authenticated principal has all of roles. Requests without a verified principal are answered with 401 Unauthorized and a Bearer challenge, and requests lacking a role with 403 Forbidden. Use an Authorizer to send another challenge.
+func RequireRoles(roles ...string) func(http.Handler) http.Handler {
+	return defaultAuthorizer.RequireRoles(roles...)
+}
+
+//RequireAnyRole works like RequireRoles, but only requires one of roles
+func RequireAnyRole(roles ...string) func(http.Handler) http.Handler {
+	return defaultAuthorizer.RequireAnyRole(roles...)
+}
+
+//RequireScopes works like the package level RequireScopes, using the challenge and ErrorResponder of a
+func (a *Authorizer) RequireScopes(scopes ...string) func(http.Handler) http.Handler {
+	return a.scopeHandler(scopes, true)
+}
+
+//RequireAnyScope works like the package level RequireAnyScope, using the challenge and ErrorResponder of a
+func (a *Authorizer) RequireAnyScope(scopes ...string) func(http.Handler) http.Handler {
+	return a.scopeHandler(scopes, false)
+}
+
+//RequireRoles works like the package level RequireRoles, using the challenge and ErrorResponder of a
+func (a *Authorizer) RequireRoles(roles ...string) func(http.Handler) http.Handler {
+	return a.roleHandler(roles, true)
+}
+
+//RequireAnyRole works like the package level RequireAnyRole, using the challenge and ErrorResponder of a
+func (a *Authorizer) RequireAnyRole(roles ...string) func(http.Handler) http.Handler {
+	return a.roleHandler(roles, false)
+}
+
+func (a *Authorizer) scopeHandler(scopes []string, all bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, err := PrincipalFrom(r.Context())
+			if err != nil || !IsVerified(p) {
+				a.respond(w, r, http.StatusUnauthorized, a.challenge, ErrNotAuthenticated)
+				return
+			}
+			sp, ok := p.(ScopedPrincipal)
+			if !ok || !matchAll(scopes, sp.HasScope, all) {
+				challenge := fmt.Sprintf(`Bearer error="insufficient_scope", scope=%s`, quoteParam(strings.Join(scopes, " ")))
+				a.respond(w, r, http.StatusForbidden, challenge, ErrInsufficientScope)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (a *Authorizer) roleHandler(roles []string, all bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, err := PrincipalFrom(r.Context())
+			if err != nil || !IsVerified(p) {
+				a.respond(w, r, http.StatusUnauthorized, a.challenge, ErrNotAuthenticated)
+				return
+			}
+			rp, ok := p.(RolePrincipal)
+			if !ok || !matchAll(roles, rp.HasRole, all) {
+				a.respond(w, r, http.StatusForbidden, "", ErrMissingRole)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// matchAll reports whether has is true for all of required, or for any of them if all is false. An empty list always matches.
+func matchAll(required []string, has func(string) bool, all bool) bool {
+	if len(required) == 0 {
+		return true
+	}
+	for _, s := range required {
+		if has(s) != all {
+			return !all
+		}
+	}
+	return all
+}
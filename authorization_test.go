@@ -0,0 +1,176 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchAll(t *testing.T) {
+	has := func(s string) bool { return s == "a" || s == "b" }
+	assert.True(t, matchAll([]string{"a", "b"}, has, true), "should be true")
+	assert.False(t, matchAll([]string{"a", "c"}, has, true), "should be false")
+	assert.True(t, matchAll([]string{"c", "a"}, has, false), "should be true")
+	assert.False(t, matchAll([]string{"c", "d"}, has, false), "should be false")
+	assert.True(t, matchAll(nil, has, false), "should be true")
+}
+
+func TestRequireScopes(t *testing.T) {
+	tom := &Identity{Name: "tom", Scopes: []string{"orders:read", "orders:write"}, Roles: []string{"admin"}}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	serve := func(h http.Handler, p Principal) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/orders", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p != nil {
+			req = req.WithContext(withPrincipal(context.Background(), p))
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("All of scopes", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve(RequireScopes("orders:read", "orders:write")(ok), tom).Code, "should be equal")
+
+		rr := serve(RequireScopes("orders:read", "orders:delete")(ok), tom)
+		assert.Equal(t, http.StatusForbidden, rr.Code, "should be equal")
+		assert.Equal(t, `Bearer error="insufficient_scope", scope="orders:read orders:delete"`, rr.Header().Get("WWW-Authenticate"), "should be equal")
+	})
+
+	t.Run("Any of scopes", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve(RequireAnyScope("orders:delete", "orders:write")(ok), tom).Code, "should be equal")
+		assert.Equal(t, http.StatusForbidden, serve(RequireAnyScope("billing:read")(ok), tom).Code, "should be equal")
+	})
+
+	t.Run("Roles", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve(RequireRoles("admin")(ok), tom).Code, "should be equal")
+		assert.Equal(t, http.StatusOK, serve(RequireAnyRole("auditor", "admin")(ok), tom).Code, "should be equal")
+
+		rr := serve(RequireRoles("admin", "auditor")(ok), tom)
+		assert.Equal(t, http.StatusForbidden, rr.Code, "should be equal")
+		assert.Empty(t, rr.Header().Get("WWW-Authenticate"), "should be empty")
+	})
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		rr := serve(RequireScopes("orders:read")(ok), nil)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code, "should be equal")
+		assert.Equal(t, "Bearer", rr.Header().Get("WWW-Authenticate"), "should be equal")
+		rr = serve(RequireRoles("admin")(ok), nil)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code, "should be equal")
+		assert.Equal(t, "Bearer", rr.Header().Get("WWW-Authenticate"), "should be equal")
+
+		unverified := &Identity{Name: "tom", Method: AuthMethodBasicUnverified, Scopes: tom.Scopes, Roles: tom.Roles}
+		assert.Equal(t, http.StatusUnauthorized, serve(RequireScopes("orders:read")(ok), unverified).Code, "should be equal")
+		assert.Equal(t, http.StatusUnauthorized, serve(RequireRoles("admin")(ok), unverified).Code, "should be equal")
+	})
+
+	t.Run("Principal without scopes", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, serve(RequireScopes("orders:read")(ok), subjectOnly("tom")).Code, "should be equal")
+	})
+
+	t.Run("Scopes from JWT claims", func(t *testing.T) {
+		secret := []byte("thisisasecret")
+		v, err := NewJWTValidator(JWTOptions{Keys: StaticKeys{"": secret}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		token := signTestJWT(t, "HS256", "", secret, map[string]interface{}{"sub": "tom", "scope": "orders:read", "roles": []string{"admin"}})
+		req, err := http.NewRequest("GET", "/orders", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		rr := httptest.NewRecorder()
+		v.Handler(RequireScopes("orders:read")(RequireRoles("admin")(ok))).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code, "should be equal")
+
+		rr = httptest.NewRecorder()
+		v.Handler(RequireScopes("orders:write")(ok)).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusForbidden, rr.Code, "should be equal")
+	})
+
+	t.Run("Authorizer", func(t *testing.T) {
+		var rejected []error
+		a, err := NewAuthorizer(AuthorizationOptions{
+			Challenge: `Basic realm="admin"`,
+			ErrorResponder: func(w http.ResponseWriter, r *http.Request, status int, challenge string, err error) {
+				rejected = append(rejected, err)
+				DefaultErrorResponder(w, r, status, challenge, err)
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, http.StatusOK, serve(a.RequireRoles("admin")(ok), tom).Code, "should be equal")
+
+		rr := serve(a.RequireAnyRole("admin")(ok), nil)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code, "should be equal")
+		assert.Equal(t, `Basic realm="admin"`, rr.Header().Get("WWW-Authenticate"), "should be equal")
+		rr = serve(a.RequireScopes("orders:read")(ok), nil)
+		assert.Equal(t, `Basic realm="admin"`, rr.Header().Get("WWW-Authenticate"), "should be equal")
+
+		assert.Equal(t, http.StatusForbidden, serve(a.RequireRoles("auditor")(ok), tom).Code, "should be equal")
+		assert.Equal(t, http.StatusForbidden, serve(a.RequireAnyScope("billing:read")(ok), tom).Code, "should be equal")
+		assert.Equal(t, []error{ErrNotAuthenticated, ErrNotAuthenticated, ErrMissingRole, ErrInsufficientScope}, rejected, "should be equal")
+	})
+}
+
+// subjectOnly is a Principal that only has a subject
+type subjectOnly string
+
+func (s subjectOnly) Subject() string {
+	return string(s)
+}
+
+func (s subjectOnly) AuthMethod() string {
+	return "test"
+}
+
+func (s subjectOnly) Attributes() map[string]string {
+	return nil
+}
+
+func (s subjectOnly) Claims() map[string]interface{} {
+	return nil
+}
+
+func ExampleRequireScopes() {
+	v, err := NewJWTValidator(JWTOptions{Keys: StaticKeys{"": []byte("secret")}})
+	if err != nil {
+		// error handling
+	}
+	ordersHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// do something
+	})
+
+	http.Handle("/orders", v.Handler(RequireScopes("orders:write")(ordersHandler)))
+	http.Handle("/admin", v.Handler(RequireAnyRole("admin", "owner")(ordersHandler)))
+	http.ListenAndServe(":3000", nil)
+}
+
+func ExampleAuthorizer() {
+	v, err := NewBasicValidator(BasicAuthOptions{Realm: "admin", Authenticator: AuthenticatorFunc(func(user, pass string, r *http.Request) (Principal, error) {
+		// look up the user and its roles
+		return &Identity{Name: user, Method: AuthMethodBasic, Roles: []string{"admin"}}, nil
+	})})
+	if err != nil {
+		// error handling
+	}
+	a, err := NewAuthorizer(AuthorizationOptions{Challenge: `Basic realm="admin"`})
+	if err != nil {
+		// error handling
+	}
+	adminHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// do something
+	})
+
+	http.Handle("/admin", v.Handler(a.RequireRoles("admin")(adminHandler)))
+	http.ListenAndServe(":3000", nil)
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"unicode/utf8"
 )
 
 type basicAuth struct {
@@ -14,62 +15,208 @@ type basicAuth struct {
 	pass string
 }
 
-//BasicAuthorizationHandler extracts any Authorization info of type Basic.
+// Errors returned by BasicCredentials when the Basic credentials of the request are missing or malformed, use errors.Is to check for them
+var (
+	ErrBasicAuthMissing   = errors.New("no basic authorization header found")
+	ErrBasicAuthEncoding  = errors.New("malformed basic authorization encoding")
+	ErrBasicAuthMalformed = errors.New("malformed basic authorization credentials")
+)
+
+// basicAuthError carries a descriptive message while matching one of the ErrBasicAuth* errors
+type basicAuthError struct {
+	kind error
+	msg  string
+}
+
+func (e *basicAuthError) Error() string {
+	return e.msg
+}
+
+func (e *basicAuthError) Is(target error) bool {
+	return target == e.kind
+}
+
+//Authenticator validates the credentials of a request and returns the Principal they belong to, or an error if they are invalid
+type Authenticator interface {
+	Authenticate(user, pass string, r *http.Request) (Principal, error)
+}
+
+//AuthenticatorFunc is an adapter to allow the use of ordinary functions as an Authenticator
+type AuthenticatorFunc func(user, pass string, r *http.Request) (Principal, error)
+
+//Authenticate calls f(user, pass, r)
+func (f AuthenticatorFunc) Authenticate(user, pass string, r *http.Request) (Principal, error) {
+	return f(user, pass, r)
+}
+
+//BasicAuthOptions holds the settings used to build a BasicValidator
+type BasicAuthOptions struct {
+	Realm         string        // realm sent in the WWW-Authenticate challenge, defaults to "Restricted"
+	Authenticator Authenticator // validates the user and password, required
+	UTF8          bool          // advertises charset="UTF-8" in the challenge (RFC 7617) and rejects credentials that aren't valid UTF-8
+}
+
+//BasicValidator is a validating variant of BasicAuthorizationHandler, requests without valid credentials are rejected with 401 Unauthorized
+type BasicValidator struct {
+	realm string
+	auth  Authenticator
+	utf8  bool
+}
+
+//NewBasicValidator returns a BasicValidator using the settings in opts, or an error if no Authenticator is given
+func NewBasicValidator(opts BasicAuthOptions) (*BasicValidator, error) {
+	if opts.Authenticator == nil {
+		return nil, errors.New("basic validator requires an Authenticator")
+	}
+	v := &BasicValidator{realm: opts.Realm, auth: opts.Authenticator, utf8: opts.UTF8}
+	if v.realm == "" {
+		v.realm = "Restricted"
+	}
+	return v, nil
+}
+
+//BasicAuthorizationHandler extracts any Authorization info of type Basic, as described in RFC 7617. Use a BasicExtractor to reject requests without credentials or to silence the warnings.
 func BasicAuthorizationHandler(next http.Handler) http.Handler {
+	return defaultBasicExtractor.Handler(next)
+}
+
+//BasicExtractorOptions holds the settings used to build a BasicExtractor
+type BasicExtractorOptions struct {
+	Mode           AuthMode       // how requests with missing or malformed credentials are treated, defaults to AuthOptional
+	Realm          string         // realm sent in the WWW-Authenticate challenge, defaults to "Restricted"
+	ErrorResponder ErrorResponder // answers rejected requests, defaults to DefaultErrorResponder
+}
+
+//BasicExtractor extracts Basic credentials like BasicAuthorizationHandler, without verifying them, but lets the treatment of requests without valid credentials be configured
+type BasicExtractor struct {
+	mode    AuthMode
+	realm   string
+	respond ErrorResponder
+}
+
+var defaultBasicExtractor = &BasicExtractor{realm: "Restricted", respond: DefaultErrorResponder}
+
+//NewBasicExtractor returns a BasicExtractor using the settings in opts, or an error if the mode is unknown
+func NewBasicExtractor(opts BasicExtractorOptions) (*BasicExtractor, error) {
+	if err := opts.Mode.validate(); err != nil {
+		return nil, err
+	}
+	e := &BasicExtractor{mode: opts.Mode, realm: opts.Realm, respond: opts.ErrorResponder}
+	if e.realm == "" {
+		e.realm = defaultBasicExtractor.realm
+	}
+	if e.respond == nil {
+		e.respond = DefaultErrorResponder
+	}
+	return e, nil
+}
+
+//Handler returns a http.Handler that stores the Basic credentials of the request in the context, where they can be read using BasicCredentials, before calling next. The user is available as an unverified Principal through PrincipalFrom. In AuthRequired mode requests with missing or malformed credentials are answered with 401 Unauthorized and a WWW-Authenticate challenge.
+func (e *BasicExtractor) Handler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		hash, err := basicAuthHash(r.Header)
+		auth, err := parseBasicAuth(r.Header)
 		if err != nil {
-			fmt.Fprintf(output, "warn: %s\n", err.Error())
-			next.ServeHTTP(w, r)
+			if !authFailure(e.mode, e.respond, w, r, http.StatusUnauthorized, basicChallenge(e.realm, false), err) {
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authContextKey, err)))
+			}
 			return
 		}
-		plain, err := decodeBase64(hash)
-		if err != nil {
-			fmt.Fprintf(output, "warn: %s\n", err.Error())
-			next.ServeHTTP(w, r)
+		ctx := context.WithValue(r.Context(), authContextKey, auth)
+		ctx = withPrincipal(ctx, &Identity{Name: auth.user, Method: AuthMethodBasicUnverified})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+//Handler returns a http.Handler that validates the Basic credentials of the request before calling next. On success the credentials are available through BasicCredentials and the authenticated Principal through PrincipalFrom, otherwise the request is answered with 401 Unauthorized and a WWW-Authenticate challenge.
+func (v *BasicValidator) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth, err := parseBasicAuth(r.Header)
+		if err != nil || (v.utf8 && !(utf8.ValidString(auth.user) && utf8.ValidString(auth.pass))) {
+			v.challenge(w)
 			return
 		}
-		ctx := context.WithValue(r.Context(), authContextKey, newBasicAuth(plain))
-		next.ServeHTTP(w, r.WithContext(ctx))
+		p, err := v.auth.Authenticate(auth.user, auth.pass, r)
+		if err != nil || p == nil {
+			v.challenge(w)
+			return
+		}
+		ctx := context.WithValue(r.Context(), authContextKey, auth)
+		next.ServeHTTP(w, r.WithContext(withPrincipal(ctx, withMethod(p, AuthMethodBasic))))
 	})
 }
 
-//BasicCredentials returns the user, pass or an error if the values could not be handled or doesn't exist in the context
+func (v *BasicValidator) challenge(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", basicChallenge(v.realm, v.utf8))
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+// basicChallenge returns a RFC 7617 WWW-Authenticate challenge for realm
+func basicChallenge(realm string, utf8 bool) string {
+	challenge := fmt.Sprintf("Basic realm=%s", quoteParam(realm))
+	if utf8 {
+		challenge += `, charset="UTF-8"`
+	}
+	return challenge
+}
+
+// quoteParam returns s as a quoted-string suitable for use as an auth-param value
+func quoteParam(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+//BasicCredentials returns the user, pass or an error if the values could not be handled or doesn't exist in the context. If BasicAuthorizationHandler found missing or malformed credentials the error matches ErrBasicAuthMissing, ErrBasicAuthEncoding or ErrBasicAuthMalformed.
 func BasicCredentials(ctx context.Context) (user, pass string, err error) {
-	auth, ok := ctx.Value(authContextKey).(basicAuth)
-	if !ok {
-		return "", "", errors.New("no basic authorization data could be found")
+	switch auth := ctx.Value(authContextKey).(type) {
+	case basicAuth:
+		return auth.user, auth.pass, nil
+	case error:
+		return "", "", auth
 	}
-	return auth.user, auth.pass, nil
+	return "", "", errors.New("no basic authorization data could be found")
 }
 
+func parseBasicAuth(h http.Header) (a basicAuth, err error) {
+	hash, err := basicAuthHash(h)
+	if err != nil {
+		return a, err
+	}
+	plain, err := decodeBase64(hash)
+	if err != nil {
+		return a, &basicAuthError{ErrBasicAuthEncoding, fmt.Sprintf("%s: %s", ErrBasicAuthEncoding.Error(), err.Error())}
+	}
+	a = newBasicAuth(plain)
+	if a.user == "" {
+		return a, ErrBasicAuthMalformed
+	}
+	return a, nil
+}
+
+// basicAuthHash returns the base64 encoded credentials of the Authorization header, the scheme is matched case-insensitively
 func basicAuthHash(h http.Header) (b64 string, err error) {
-	auth := h.Get("Authorization")
-	if !strings.HasPrefix(auth, "Basic ") {
-		return "", errors.New("no basic authorization header found")
+	scheme, hash, _ := strings.Cut(strings.TrimSpace(h.Get("Authorization")), " ")
+	if !strings.EqualFold(scheme, "Basic") {
+		return "", ErrBasicAuthMissing
 	}
-	hash := auth[6:]
+	hash = strings.TrimSpace(hash)
 	if !(len(hash) > 0) {
-		return "", errors.New("empty basic authorization hash")
+		return "", &basicAuthError{ErrBasicAuthEncoding, "empty basic authorization hash"}
 	}
 	return hash, nil
 }
 
 func decodeBase64(b64 string) (plain string, err error) {
-	d, err := base64.URLEncoding.DecodeString(b64)
+	d, err := base64.StdEncoding.DecodeString(b64)
 	return string(d), err
 }
 
+// newBasicAuth splits plain on the first colon, since the password may contain colons but the user may not. RFC 7617 allows an empty password, but not an empty user-id.
 func newBasicAuth(plain string) (a basicAuth) {
 	a = basicAuth{}
-	s := strings.Split(plain, ":")
-	if len(s) != 2 {
-		return
-	}
-	if !(len(s[0]) > 0) || !(len(s[1]) > 0) {
+	user, pass, ok := strings.Cut(plain, ":")
+	if !ok || !(len(user) > 0) {
 		return
 	}
-	a.user = s[0]
-	a.pass = s[1]
+	a.user = user
+	a.pass = pass
 	return
 }
@@ -1,15 +1,13 @@
 package middlewares
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
-)
-
-var (
-	allowedCORSMethods = []string{}
-	allowedOrigins     = []string{}
-	allowedHeaders     = []string{}
-	exposedHeaders     = []string{}
+	"time"
 )
 
 var (
@@ -23,96 +21,369 @@ var (
 	corsAccessControlAllowCredentials = "Access-Control-Allow-Credentials" // when used as part of preflight response, indicates if the actual request can be made using credentials
 	corsAccessControlAllowMethods     = "Access-Control-Allow-Methods"     // method or methods allowed for the resource <method>[, <method>]*
 	corsAccessControlAllowHeaders     = "Access-Control-Allow-Headers"     // indicate which http headers that can be used when making the actual request
+
+	corsAccessControlRequestPrivateNetwork = "Access-Control-Request-Private-Network" // sent on preflights from a public site to a private network address
+	corsAccessControlAllowPrivateNetwork   = "Access-Control-Allow-Private-Network"   // allows the public site to reach the private network address
 )
 
-var (
+//CORSOptions holds the settings used to build a CORSPolicy
+type CORSOptions struct {
+	AllowedOrigins   []string // origins allowed to make cross-origin requests, "*" allows any origin and "https://*.example.com" allows any subdomain of example.com
+	AllowedMethods   []string // methods allowed for the actual request, "*" allows any method
+	AllowedHeaders   []string // headers that can be used when making the actual request
+	ExposedHeaders   []string // headers that browsers are allowed to access
+	AllowCredentials bool     // sets Access-Control-Allow-Credentials, disallowed by default
+
+	MaxAge             time.Duration // how long the result of a preflight request can be cached, sent as Access-Control-Max-Age in whole seconds
+	PreflightStatus    int           // status code used to answer successful preflight requests, defaults to 204 No Content
+	OptionsPassthrough bool          // when true, preflight requests are decorated and then passed on to the next handler instead of being answered directly
+
+	AllowedOriginPatterns []string                                  // regular expressions matched against the whole origin
+	AllowOriginFunc       func(origin string, r *http.Request) bool // called for origins not matched by AllowedOrigins or AllowedOriginPatterns
+
+	AllowPrivateNetwork bool // answers Private Network Access preflights (Access-Control-Request-Private-Network) with Access-Control-Allow-Private-Network, see https://wicg.github.io/private-network-access/
+}
+
+//CORSPolicy is a set of CORS rules. Each policy is independent of the others, so several routers in the same binary can use different rules.
+type CORSPolicy struct {
+	allowedOrigins     []string
+	originWildcards    []wildcardOrigin
+	originPatterns     []*regexp.Regexp
+	originFunc         func(origin string, r *http.Request) bool
+	allowedMethods     []string
+	allowedHeaders     []string
+	exposedHeaders     []string
 	supportCredentials bool
-)
+	maxAge             time.Duration
+	preflightStatus    int
+	optionsPassthrough bool
+	privateNetwork     bool
+	err                error // first invalid change made by the AllowCORS* functions, only set on the default policy
+}
+
+// defaultCORSPolicy is the policy used by CORSHandler and modified by the AllowCORS* functions
+var defaultCORSPolicy = &CORSPolicy{preflightStatus: http.StatusNoContent}
+
+// wildcardOrigin matches origins of the form <prefix><subdomain><suffix>, e.g. "https://" + "preview-1" + ".example.com"
+type wildcardOrigin struct {
+	prefix string
+	suffix string
+}
+
+//NewCORSPolicy returns a CORSPolicy using the settings in opts, or an error if any of the origins or origin patterns are malformed or if credentials are allowed together with the "*" origin
+func NewCORSPolicy(opts CORSOptions) (*CORSPolicy, error) {
+	p := &CORSPolicy{
+		originFunc:         opts.AllowOriginFunc,
+		allowedMethods:     append([]string{}, opts.AllowedMethods...),
+		allowedHeaders:     append([]string{}, opts.AllowedHeaders...),
+		exposedHeaders:     append([]string{}, opts.ExposedHeaders...),
+		supportCredentials: opts.AllowCredentials,
+		maxAge:             opts.MaxAge,
+		preflightStatus:    opts.PreflightStatus,
+		optionsPassthrough: opts.OptionsPassthrough,
+		privateNetwork:     opts.AllowPrivateNetwork,
+	}
+	if p.preflightStatus == 0 {
+		p.preflightStatus = http.StatusNoContent
+	}
+	if err := p.addOrigins(opts.AllowedOrigins...); err != nil {
+		return nil, err
+	}
+	for _, pattern := range opts.AllowedOriginPatterns {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid CORS origin pattern %q: %s", pattern, err.Error())
+		}
+		p.originPatterns = append(p.originPatterns, re)
+	}
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// validate returns an error if the policy contains a combination of settings that browsers reject
+func (p *CORSPolicy) validate() error {
+	if p.supportCredentials && p.allowAll() {
+		return errors.New("invalid CORS policy: credentials can't be allowed for the \"*\" origin")
+	}
+	return nil
+}
+
+// addOrigins adds exact, "*" and subdomain wildcard origins to the policy
+func (p *CORSPolicy) addOrigins(origins ...string) error {
+	for _, o := range origins {
+		if o == "*" || !strings.Contains(o, "*") {
+			p.allowedOrigins = append(p.allowedOrigins, o)
+			continue
+		}
+		wo, err := parseWildcardOrigin(o)
+		if err != nil {
+			return err
+		}
+		p.originWildcards = append(p.originWildcards, wo)
+	}
+	return nil
+}
+
+// parseWildcardOrigin parses origins like "https://*.example.com" where the wildcard replaces one or more subdomain labels
+func parseWildcardOrigin(o string) (wo wildcardOrigin, err error) {
+	i := strings.Index(o, "*")
+	wo.prefix, wo.suffix = strings.ToLower(o[:i]), strings.ToLower(o[i+1:])
+	if strings.Contains(wo.suffix, "*") {
+		return wo, fmt.Errorf("invalid CORS origin %q: only one wildcard is allowed", o)
+	}
+	if !strings.HasSuffix(wo.prefix, "://") || len(wo.prefix) == 3 {
+		return wo, fmt.Errorf("invalid CORS origin %q: the wildcard must directly follow the scheme", o)
+	}
+	if !strings.HasPrefix(wo.suffix, ".") || len(wo.suffix) < 2 || strings.ContainsAny(wo.suffix, "/?#@") {
+		return wo, fmt.Errorf("invalid CORS origin %q: the wildcard must be followed by a domain", o)
+	}
+	return wo, nil
+}
 
-func init() {
-	supportCredentials = false //disallow credentials by default
+func (wo wildcardOrigin) match(o string) bool {
+	o = strings.ToLower(o)
+	if len(o) <= len(wo.prefix)+len(wo.suffix) || !strings.HasPrefix(o, wo.prefix) || !strings.HasSuffix(o, wo.suffix) {
+		return false
+	}
+	sub := o[len(wo.prefix) : len(o)-len(wo.suffix)]
+	return !strings.ContainsAny(sub, "/:@?#") && !strings.HasPrefix(sub, ".") && !strings.HasSuffix(sub, ".")
 }
 
 //AllowCORSMethods specified which methods that are allowed for CORS
 func AllowCORSMethods(methods ...string) {
-	allowedCORSMethods = append(allowedCORSMethods, methods...)
+	updateDefaultCORSPolicy(func(p *CORSPolicy) error {
+		p.allowedMethods = append(p.allowedMethods, methods...)
+		return nil
+	})
 }
 
-//AllowCORSOrigins specifies which origins to allow
+//AllowCORSOrigins specifies which origins to allow. Subdomain wildcards such as "https://*.example.com" are supported.
+//
+// A malformed wildcard origin, or allowing "*" after SupportCredentials(true), isn't applied and is reported by DefaultCORSPolicyErr. Use NewCORSPolicy to get the error directly.
 func AllowCORSOrigins(origins ...string) {
-	allowedOrigins = append(allowedOrigins, origins...)
+	updateDefaultCORSPolicy(func(p *CORSPolicy) error {
+		return p.addOrigins(origins...)
+	})
 }
 
 //AllowCORSHeaders specifies which headers that can be used
 func AllowCORSHeaders(headers ...string) {
-	allowedHeaders = append(allowedHeaders, headers...)
+	updateDefaultCORSPolicy(func(p *CORSPolicy) error {
+		p.allowedHeaders = append(p.allowedHeaders, headers...)
+		return nil
+	})
 }
 
 //AllowCORSExposedHeaders whitelists which headers are allowed for the browsers to access
 func AllowCORSExposedHeaders(headers ...string) {
-	exposedHeaders = append(exposedHeaders, headers...)
+	updateDefaultCORSPolicy(func(p *CORSPolicy) error {
+		p.exposedHeaders = append(p.exposedHeaders, headers...)
+		return nil
+	})
 }
 
-//SupportCredentials sets the Support-Credentials header to b
+//SupportCredentials sets the Support-Credentials header to b.
+//
+// Browsers reject credentials for the "*" origin, so enabling them after AllowCORSOrigins("*") isn't applied and is reported by DefaultCORSPolicyErr. Use NewCORSPolicy to get the error directly.
 func SupportCredentials(b bool) {
-	supportCredentials = b
+	updateDefaultCORSPolicy(func(p *CORSPolicy) error {
+		p.supportCredentials = b
+		return nil
+	})
+}
+
+//AllowCORSPrivateNetwork sets whether Private Network Access preflights are allowed
+func AllowCORSPrivateNetwork(b bool) {
+	updateDefaultCORSPolicy(func(p *CORSPolicy) error {
+		p.privateNetwork = b
+		return nil
+	})
+}
+
+//DefaultCORSPolicyErr returns the error of the first invalid change made to the default policy with the AllowCORS* functions or SupportCredentials, or nil if every change was valid. Invalid changes aren't applied, and CORSHandler fails closed while there is an error: no CORS headers are sent and preflight requests are rejected with 403 Forbidden.
+func DefaultCORSPolicyErr() error {
+	return defaultCORSPolicy.err
+}
+
+// updateDefaultCORSPolicy applies update to a copy of the default policy, which replaces the default policy if it's valid. Otherwise the error is recorded, since the AllowCORS* functions can't return it.
+func updateDefaultCORSPolicy(update func(p *CORSPolicy) error) {
+	p := defaultCORSPolicy.clone()
+	err := update(p)
+	if err == nil {
+		err = p.validate()
+	}
+	if err != nil {
+		fmt.Fprintf(output, "warn: %s\n", err.Error())
+		if defaultCORSPolicy.err == nil {
+			defaultCORSPolicy.err = err
+		}
+		return
+	}
+	*defaultCORSPolicy = *p
+}
+
+// clone returns a copy of p that shares none of its slices
+func (p *CORSPolicy) clone() *CORSPolicy {
+	c := *p
+	c.allowedOrigins = append([]string(nil), p.allowedOrigins...)
+	c.originWildcards = append([]wildcardOrigin(nil), p.originWildcards...)
+	c.originPatterns = append([]*regexp.Regexp(nil), p.originPatterns...)
+	c.allowedMethods = append([]string(nil), p.allowedMethods...)
+	c.allowedHeaders = append([]string(nil), p.allowedHeaders...)
+	c.exposedHeaders = append([]string(nil), p.exposedHeaders...)
+	return &c
 }
 
-//CORSHandler appends CORS headers to the response if any CORS headers are present in the request or as a preflight request. For detailed documentation regarding CORS and what headers mean, please see https://developer.mozilla.org/en-US/docs/Web/HTTP/CORS
+//CORSHandler appends CORS headers to the response if any CORS headers are present in the request or as a preflight request. It uses the package default policy, configured with the AllowCORS* functions, and sends no CORS headers if DefaultCORSPolicyErr returns an error. For detailed documentation regarding CORS and what headers mean, please see https://developer.mozilla.org/en-US/docs/Web/HTTP/CORS
 func CORSHandler(next http.Handler) http.Handler {
+	return defaultCORSPolicy.Handler(next)
+}
+
+//Handler returns a http.Handler that wraps next and appends CORS headers to the response according to the policy. Preflight requests (OPTIONS with an Access-Control-Request-Method header) are answered directly and never reach next, unless OptionsPassthrough is set. Preflights asking for a method or headers that aren't allowed are rejected with 403 Forbidden and no CORS headers.
+func (p *CORSPolicy) Handler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		origin := r.Header.Get(corsOrigin)
-		if !isAllowedOrigin(origin) {
+		if p.err != nil {
+			// the default policy is misconfigured, fail closed
+			if isPreflight(r) && !p.optionsPassthrough {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !isPreflight(r) {
+			p.handleActual(w, r)
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		w.Header().Set(corsAccessControlAllowOrigin, origin) // we allow this origin
+		ok := p.handlePreflight(w, r)
+		if p.optionsPassthrough {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !ok {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(p.preflightStatus)
+	})
+}
 
-		if r.Method == http.MethodOptions {
-			if isAllowedMethod(r.Header.Get(corsAccessControlRequestMethod)) {
-				w.Header().Set(corsAccessControlAllowMethods, strings.Join(allowedCORSMethods, ", "))
-			}
-			w.Header().Set(corsAccessControlMaxAge, "0")
+// isPreflight reports whether r is a CORS preflight request
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get(corsAccessControlRequestMethod) != ""
+}
 
-			//Request-headers are only set on preflight requests
-			sv := getAllowedHeaders(strings.Split(r.Header.Get(corsAccessControlRequestHeaders), ","))
-			if len(sv) > 0 {
-				w.Header().Set(corsAccessControlAllowHeaders, strings.Join(sv, ", "))
-			}
+// handlePreflight sets the preflight response headers and reports whether the preflight was accepted
+func (p *CORSPolicy) handlePreflight(w http.ResponseWriter, r *http.Request) bool {
+	addVary(w.Header(), corsOrigin, corsAccessControlRequestMethod, corsAccessControlRequestHeaders, corsAccessControlRequestPrivateNetwork)
+	origin := r.Header.Get(corsOrigin)
+	if !p.isAllowedOrigin(origin, r) {
+		return false
+	}
+	method := r.Header.Get(corsAccessControlRequestMethod)
+	if !p.isAllowedMethod(method) {
+		return false
+	}
+	reqHeaders := splitHeaderList(r.Header.Get(corsAccessControlRequestHeaders))
+	sv := p.getAllowedHeaders(reqHeaders)
+	if len(sv) != len(reqHeaders) {
+		return false // at least one of the requested headers isn't allowed
+	}
 
-			if len(exposedHeaders) > 0 {
-				w.Header().Set(corsAccessControlExposeHeaders, strings.Join(exposedHeaders, ", "))
-			}
-		}
+	p.setAllowOrigin(w, origin)
+	if containsString(p.allowedMethods, "*") {
+		w.Header().Set(corsAccessControlAllowMethods, method) // browsers take "*" as a method name on credentialed requests
+	} else {
+		w.Header().Set(corsAccessControlAllowMethods, strings.Join(p.allowedMethods, ", "))
+	}
+	if len(sv) > 0 {
+		w.Header().Set(corsAccessControlAllowHeaders, strings.Join(sv, ", "))
+	}
+	w.Header().Set(corsAccessControlMaxAge, strconv.Itoa(int(p.maxAge/time.Second)))
+	if p.privateNetwork && r.Header.Get(corsAccessControlRequestPrivateNetwork) == "true" {
+		w.Header().Set(corsAccessControlAllowPrivateNetwork, "true")
+	}
+	return true
+}
 
-		if allowAll() {
-			if allowAll() {
-				w.Header().Set(corsVary, "Origin") // tell the client the origin might be changing depending on who's asking
-			}
+// handleActual sets the response headers for an actual (non-preflight) cross-origin request
+func (p *CORSPolicy) handleActual(w http.ResponseWriter, r *http.Request) {
+	if !p.literalWildcard() {
+		addVary(w.Header(), corsOrigin) // the response depends on who's asking, even when the origin is rejected
+	}
+	origin := r.Header.Get(corsOrigin)
+	if !p.isAllowedOrigin(origin, r) {
+		return
+	}
+	p.setAllowOrigin(w, origin)
+	if len(p.exposedHeaders) > 0 {
+		w.Header().Set(corsAccessControlExposeHeaders, strings.Join(p.exposedHeaders, ", "))
+	}
+}
+
+// setAllowOrigin sets the allowed origin, and the credentials header if supported
+func (p *CORSPolicy) setAllowOrigin(w http.ResponseWriter, origin string) {
+	if p.literalWildcard() {
+		w.Header().Set(corsAccessControlAllowOrigin, "*")
+		return
+	}
+	w.Header().Set(corsAccessControlAllowOrigin, origin) // we allow this origin
+	if p.supportCredentials {
+		w.Header().Set(corsAccessControlAllowCredentials, "true")
+	}
+}
+
+// literalWildcard reports whether the policy answers every origin with a literal "*"
+func (p *CORSPolicy) literalWildcard() bool {
+	return p.allowAll() && !p.supportCredentials
+}
+
+// addVary appends values to the Vary header of h, skipping any values already present
+func addVary(h http.Header, values ...string) {
+	existing := map[string]bool{}
+	for _, v := range h.Values(corsVary) {
+		for _, s := range splitHeaderList(v) {
+			existing[strings.ToLower(s)] = true
 		}
+	}
+	for _, v := range values {
+		if !existing[strings.ToLower(v)] {
+			h.Add(corsVary, v)
+			existing[strings.ToLower(v)] = true
+		}
+	}
+}
 
-		if supportCredentials {
-			w.Header().Set(corsAccessControlAllowCredentials, "true")
+// splitHeaderList splits a comma separated header value, dropping empty entries
+func splitHeaderList(v string) (list []string) {
+	list = []string{}
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
 		}
-		next.ServeHTTP(w, r)
-	})
+	}
+	return
 }
 
-func getAllowedHeaders(req []string) (all []string) {
+func (p *CORSPolicy) getAllowedHeaders(req []string) (all []string) {
 	all = []string{}
 	for _, s := range req {
-		for _, c := range allowedHeaders {
+		for _, c := range p.allowedHeaders {
 			if strings.ToLower(strings.TrimSpace(s)) == strings.ToLower(strings.TrimSpace(c)) {
 				all = append(all, c)
+				break
 			}
 		}
 	}
 	return
 }
 
-func allowAll() bool {
-	for _, ao := range allowedOrigins {
+func (p *CORSPolicy) allowAll() bool {
+	for _, ao := range p.allowedOrigins {
 		if ao == "*" {
 			return true
 		}
@@ -120,23 +391,33 @@ func allowAll() bool {
 	return false
 }
 
-func isAllowedOrigin(o string) bool {
+func (p *CORSPolicy) isAllowedOrigin(o string, r *http.Request) bool {
 	if o == "" {
 		return false
 	}
-	for _, ao := range allowedOrigins {
+	for _, ao := range p.allowedOrigins {
 		if ao == o || ao == "*" {
 			return true
 		}
 	}
-	return false
+	for _, wo := range p.originWildcards {
+		if wo.match(o) {
+			return true
+		}
+	}
+	for _, re := range p.originPatterns {
+		if re.MatchString(o) {
+			return true
+		}
+	}
+	return p.originFunc != nil && p.originFunc(o, r)
 }
 
-func isAllowedMethod(m string) bool {
+func (p *CORSPolicy) isAllowedMethod(m string) bool {
 	if m == "" {
 		return false
 	}
-	for _, am := range allowedCORSMethods {
+	for _, am := range p.allowedMethods {
 		if am == m || am == "*" {
 			return true
 		}
@@ -1,56 +1,66 @@
 package middlewares
 
 import (
+	"bytes"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
+func newTestPolicy(t *testing.T, opts CORSOptions) *CORSPolicy {
+	p, err := NewCORSPolicy(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
 func TestAllowAll(t *testing.T) {
 	t.Run("Return true on allow all", func(t *testing.T) {
-		allowedOrigins = []string{"*"}
-		assert.True(t, allowAll(), "should be true")
+		p := newTestPolicy(t, CORSOptions{AllowedOrigins: []string{"*"}})
+		assert.True(t, p.allowAll(), "should be true")
 	})
 
 	t.Run("Return false on allow all", func(t *testing.T) {
-		allowedOrigins = []string{"http://example.com"}
-		assert.False(t, allowAll(), "should be false")
+		p := newTestPolicy(t, CORSOptions{AllowedOrigins: []string{"http://example.com"}})
+		assert.False(t, p.allowAll(), "should be false")
 	})
 }
 
 func TestAllowedOrigin(t *testing.T) {
+	p := newTestPolicy(t, CORSOptions{AllowedOrigins: []string{"http://www.hawry.net", "http://www.benefactory.se"}})
+
 	t.Run("Return true if origin exists", func(t *testing.T) {
-		allowedOrigins = []string{"http://www.hawry.net", "http://www.benefactory.se"}
-		assert.True(t, isAllowedOrigin("http://www.benefactory.se"), "should be true")
+		assert.True(t, p.isAllowedOrigin("http://www.benefactory.se", nil), "should be true")
 	})
 
 	t.Run("Return false if origin doesn't exists", func(t *testing.T) {
-		allowedOrigins = []string{"http://www.hawry.net", "http://www.benefactory.se"}
-		assert.False(t, isAllowedOrigin("http://www.example.com"), "should be false")
-		assert.False(t, isAllowedOrigin(""), "should be false")
+		assert.False(t, p.isAllowedOrigin("http://www.example.com", nil), "should be false")
+		assert.False(t, p.isAllowedOrigin("", nil), "should be false")
 	})
 }
 
 func TestAllowedMethod(t *testing.T) {
+	p := newTestPolicy(t, CORSOptions{AllowedMethods: []string{"GET", "POST"}})
+
 	t.Run("Return false if method isn't specified", func(t *testing.T) {
-		allowedCORSMethods = []string{"GET", "POST"}
-		assert.False(t, isAllowedMethod("DELETE"), "should be false")
-		assert.False(t, isAllowedMethod(""), "should be false")
+		assert.False(t, p.isAllowedMethod("DELETE"), "should be false")
+		assert.False(t, p.isAllowedMethod(""), "should be false")
 	})
 
 	t.Run("Return true if method is specified", func(t *testing.T) {
-		allowedCORSMethods = []string{"GET", "POST"}
-		assert.True(t, isAllowedMethod("GET"), "should be true")
+		assert.True(t, p.isAllowedMethod("GET"), "should be true")
 	})
 }
 
 func TestAllowedHeaders(t *testing.T) {
-	allowedHeaders = []string{"X-Real-IP", "Content-Type"}
+	p := newTestPolicy(t, CORSOptions{AllowedHeaders: []string{"X-Real-IP", "Content-Type"}})
 	reqHds := []string{"X-Real-IP", "X-Requested-With"}
 	shouldBe := []string{"X-Real-IP"}
-	assert.Subset(t, getAllowedHeaders(reqHds), shouldBe)
+	assert.Subset(t, p.getAllowedHeaders(reqHds), shouldBe)
 }
 
 func TestSetMethods(t *testing.T) {
@@ -58,11 +68,9 @@ func TestSetMethods(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	//Clear any leftover data from other tests
-	allowedCORSMethods = []string{}
-	allowedHeaders = []string{}
-	allowedOrigins = []string{}
-	exposedHeaders = []string{}
+	//Restore the default policy once the test is done
+	defer func(p *CORSPolicy) { defaultCORSPolicy = p }(defaultCORSPolicy)
+	defaultCORSPolicy = newTestPolicy(t, CORSOptions{})
 	AllowCORSOrigins("http://example.com", "http://localhost")
 	AllowCORSMethods("GET", "POST")
 	AllowCORSHeaders("X-Real-IP", "Content-Type")
@@ -99,18 +107,27 @@ func TestSetMethods(t *testing.T) {
 		handler.ServeHTTP(rr, req)
 		assert.Equal(t, "http://localhost", rr.Header().Get(corsAccessControlAllowOrigin))
 		assert.Equal(t, "GET, POST", rr.Header().Get(corsAccessControlAllowMethods))
-		assert.Equal(t, "X-Exposed-Header", rr.Header().Get(corsAccessControlExposeHeaders))
 		assert.Equal(t, "X-Real-IP", rr.Header().Get(corsAccessControlAllowHeaders))
+		assert.Equal(t, "0", rr.Header().Get(corsAccessControlMaxAge))
+		assert.Equal(t, http.StatusNoContent, rr.Code)
+	})
+
+	t.Run("Actual request exposes headers", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "/index", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(corsOrigin, "http://localhost")
+		rr := httptest.NewRecorder()
+		handler := CORSHandler(corsHandler)
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, "X-Exposed-Header", rr.Header().Get(corsAccessControlExposeHeaders))
+		assert.Empty(t, rr.Header().Get(corsAccessControlAllowMethods), "should be empty")
 	})
 
 }
 
 func TestSupportCredentials(t *testing.T) {
-	allowedCORSMethods = []string{}
-	allowedHeaders = []string{}
-	allowedOrigins = []string{}
-	exposedHeaders = []string{}
-
 	req, err := http.NewRequest("GET", "/index", nil)
 	if err != nil {
 		t.Fatal(err)
@@ -118,19 +135,337 @@ func TestSupportCredentials(t *testing.T) {
 
 	req.Header.Set("Origin", "http://localhost")
 
-	SupportCredentials(true)
-	AllowCORSOrigins("*")
+	p := newTestPolicy(t, CORSOptions{
+		AllowedOrigins:   []string{"http://localhost"},
+		AllowCredentials: true,
+	})
 
 	rr := httptest.NewRecorder()
-	handler := CORSHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	handler := p.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 	}))
 	handler.ServeHTTP(rr, req)
 
 	assert.Equal(t, "true", rr.Header().Get(corsAccessControlAllowCredentials))
 	assert.NotEqual(t, "*", rr.Header().Get(corsAccessControlAllowOrigin))
-	// assert.Equal(t, "Origin", rr.Header().Get(corsVary))
+	assert.Equal(t, "Origin", rr.Header().Get(corsVary))
 	assert.Equal(t, "http://localhost", rr.Header().Get(corsAccessControlAllowOrigin))
+
+	t.Run("Rejects credentials with wildcard origin", func(t *testing.T) {
+		_, err := NewCORSPolicy(CORSOptions{AllowedOrigins: []string{"*"}, AllowCredentials: true})
+		assert.NotNil(t, err, "should not be nil")
+
+		defer func(p *CORSPolicy) { defaultCORSPolicy = p }(defaultCORSPolicy)
+		var b bytes.Buffer
+		SetOutput(&b)
+		defaultCORSPolicy = newTestPolicy(t, CORSOptions{})
+		AllowCORSOrigins("*")
+		SupportCredentials(true)
+		assert.NotNil(t, DefaultCORSPolicyErr(), "should not be nil")
+		assert.False(t, defaultCORSPolicy.supportCredentials, "should be unchanged")
+		assert.Contains(t, b.String(), "warn: invalid CORS policy", "should be reported")
+
+		defaultCORSPolicy = newTestPolicy(t, CORSOptions{})
+		SupportCredentials(true)
+		AllowCORSOrigins("https://example.com", "*")
+		assert.NotNil(t, DefaultCORSPolicyErr(), "should not be nil")
+		assert.False(t, defaultCORSPolicy.allowAll(), "should be unchanged")
+		assert.False(t, defaultCORSPolicy.isAllowedOrigin("https://example.com", nil), "should be unchanged")
+
+		AllowCORSOrigins("https://example.com")
+		AllowCORSMethods("GET")
+		assert.True(t, defaultCORSPolicy.isAllowedOrigin("https://example.com", nil), "should be true")
+		assert.NotNil(t, DefaultCORSPolicyErr(), "should still report the first error")
+	})
+
+	t.Run("Fails closed with an invalid default policy", func(t *testing.T) {
+		defer func(p *CORSPolicy) { defaultCORSPolicy = p }(defaultCORSPolicy)
+		SetOutput(&bytes.Buffer{})
+		defaultCORSPolicy = newTestPolicy(t, CORSOptions{})
+		SupportCredentials(true)
+		AllowCORSOrigins("*")
+		AllowCORSMethods("GET")
+
+		var reached bool
+		handler := CORSHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reached = true
+		}))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.True(t, reached, "should be true")
+		assert.Empty(t, rr.Header().Get(corsAccessControlAllowOrigin), "should be empty")
+		assert.Empty(t, rr.Header().Get(corsAccessControlAllowCredentials), "should be empty")
+
+		preflight, err := http.NewRequest(http.MethodOptions, "/index", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		preflight.Header.Set(corsOrigin, "http://localhost")
+		preflight.Header.Set(corsAccessControlRequestMethod, "GET")
+		reached = false
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, preflight)
+		assert.False(t, reached, "should be false")
+		assert.Equal(t, http.StatusForbidden, rr.Code, "should be equal")
+		assert.Empty(t, rr.Header().Get(corsAccessControlAllowOrigin), "should be empty")
+	})
+}
+
+func TestWildcardAndVary(t *testing.T) {
+	noop := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	vary := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add(corsVary, "Accept-Encoding")
+	})
+
+	req, err := http.NewRequest("GET", "/index", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(corsOrigin, "http://localhost")
+
+	t.Run("Wildcard is sent literally", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		newTestPolicy(t, CORSOptions{AllowedOrigins: []string{"*"}}).Handler(noop).ServeHTTP(rr, req)
+		assert.Equal(t, "*", rr.Header().Get(corsAccessControlAllowOrigin), "should be equal")
+		assert.Empty(t, rr.Header().Get(corsVary), "should be empty")
+	})
+
+	t.Run("Vary is appended", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		rr.Header().Set(corsVary, "Accept-Language")
+		p := newTestPolicy(t, CORSOptions{AllowedOrigins: []string{"http://localhost"}})
+		p.Handler(vary).ServeHTTP(rr, req)
+		assert.Equal(t, []string{"Accept-Language", "Origin", "Accept-Encoding"}, rr.Header().Values(corsVary), "should be equal")
+	})
+
+	t.Run("Vary is set for rejected origins", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		p := newTestPolicy(t, CORSOptions{AllowedOrigins: []string{"http://example.com"}})
+		p.Handler(noop).ServeHTTP(rr, req)
+		assert.Empty(t, rr.Header().Get(corsAccessControlAllowOrigin), "should be empty")
+		assert.Equal(t, "Origin", rr.Header().Get(corsVary), "should be equal")
+	})
+
+	t.Run("Preflight varies on request headers", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodOptions, "/index", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(corsOrigin, "http://localhost")
+		req.Header.Set(corsAccessControlRequestMethod, "GET")
+		rr := httptest.NewRecorder()
+		rr.Header().Set(corsVary, "origin")
+		p := newTestPolicy(t, CORSOptions{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET"}})
+		p.Handler(noop).ServeHTTP(rr, req)
+		assert.Equal(t, "*", rr.Header().Get(corsAccessControlAllowOrigin), "should be equal")
+		assert.Equal(t, []string{"origin", corsAccessControlRequestMethod, corsAccessControlRequestHeaders, corsAccessControlRequestPrivateNetwork}, rr.Header().Values(corsVary), "should be equal")
+	})
+}
+
+func TestIndependentPolicies(t *testing.T) {
+	public := newTestPolicy(t, CORSOptions{AllowedOrigins: []string{"http://example.com"}})
+	admin := newTestPolicy(t, CORSOptions{AllowedOrigins: []string{"http://admin.example.com"}})
+	noop := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	req, err := http.NewRequest("GET", "/index", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(corsOrigin, "http://example.com")
+
+	rr := httptest.NewRecorder()
+	public.Handler(noop).ServeHTTP(rr, req)
+	assert.Equal(t, "http://example.com", rr.Header().Get(corsAccessControlAllowOrigin), "should be equal")
+
+	rr = httptest.NewRecorder()
+	admin.Handler(noop).ServeHTTP(rr, req)
+	assert.Empty(t, rr.Header().Get(corsAccessControlAllowOrigin), "should be empty")
+}
+
+func TestPreflight(t *testing.T) {
+	var reached bool
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	})
+	opts := CORSOptions{
+		AllowedOrigins: []string{"http://localhost"},
+		AllowedMethods: []string{"GET", "PUT"},
+		AllowedHeaders: []string{"Content-Type", "X-Real-IP"},
+		MaxAge:         10 * time.Minute,
+	}
+
+	preflight := func(method, headers string) *http.Request {
+		req, err := http.NewRequest(http.MethodOptions, "/index", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(corsOrigin, "http://localhost")
+		req.Header.Set(corsAccessControlRequestMethod, method)
+		if headers != "" {
+			req.Header.Set(corsAccessControlRequestHeaders, headers)
+		}
+		return req
+	}
+
+	t.Run("Short-circuits allowed preflight", func(t *testing.T) {
+		reached = false
+		rr := httptest.NewRecorder()
+		newTestPolicy(t, opts).Handler(next).ServeHTTP(rr, preflight("PUT", "content-type, x-real-ip"))
+		assert.False(t, reached, "should be false")
+		assert.Equal(t, http.StatusNoContent, rr.Code, "should be equal")
+		assert.Equal(t, "600", rr.Header().Get(corsAccessControlMaxAge), "should be equal")
+		assert.Equal(t, "Content-Type, X-Real-IP", rr.Header().Get(corsAccessControlAllowHeaders), "should be equal")
+	})
+
+	t.Run("Custom preflight status", func(t *testing.T) {
+		o := opts
+		o.PreflightStatus = http.StatusOK
+		rr := httptest.NewRecorder()
+		newTestPolicy(t, o).Handler(next).ServeHTTP(rr, preflight("GET", ""))
+		assert.Equal(t, http.StatusOK, rr.Code, "should be equal")
+	})
+
+	t.Run("Echoes the method when any method is allowed", func(t *testing.T) {
+		o := opts
+		o.AllowedMethods = []string{"*"}
+		o.AllowCredentials = true
+		rr := httptest.NewRecorder()
+		newTestPolicy(t, o).Handler(next).ServeHTTP(rr, preflight("PATCH", ""))
+		assert.Equal(t, http.StatusNoContent, rr.Code, "should be equal")
+		assert.Equal(t, "PATCH", rr.Header().Get(corsAccessControlAllowMethods), "should be equal")
+		assert.Equal(t, "true", rr.Header().Get(corsAccessControlAllowCredentials), "should be equal")
+	})
+
+	t.Run("Rejects disallowed method", func(t *testing.T) {
+		reached = false
+		rr := httptest.NewRecorder()
+		newTestPolicy(t, opts).Handler(next).ServeHTTP(rr, preflight("DELETE", ""))
+		assert.False(t, reached, "should be false")
+		assert.Equal(t, http.StatusForbidden, rr.Code, "should be equal")
+		assert.Empty(t, rr.Header().Get(corsAccessControlAllowOrigin), "should be empty")
+		assert.Empty(t, rr.Header().Get(corsAccessControlAllowMethods), "should be empty")
+	})
+
+	t.Run("Rejects disallowed header", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		newTestPolicy(t, opts).Handler(next).ServeHTTP(rr, preflight("GET", "Content-Type, X-Secret"))
+		assert.Equal(t, http.StatusForbidden, rr.Code, "should be equal")
+		assert.Empty(t, rr.Header().Get(corsAccessControlAllowOrigin), "should be empty")
+	})
+
+	t.Run("Passthrough", func(t *testing.T) {
+		reached = false
+		o := opts
+		o.OptionsPassthrough = true
+		rr := httptest.NewRecorder()
+		newTestPolicy(t, o).Handler(next).ServeHTTP(rr, preflight("GET", ""))
+		assert.True(t, reached, "should be true")
+		assert.Equal(t, "http://localhost", rr.Header().Get(corsAccessControlAllowOrigin), "should be equal")
+	})
+
+	t.Run("Plain OPTIONS request is not a preflight", func(t *testing.T) {
+		reached = false
+		req, err := http.NewRequest(http.MethodOptions, "/index", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		newTestPolicy(t, opts).Handler(next).ServeHTTP(rr, req)
+		assert.True(t, reached, "should be true")
+	})
+}
+
+func TestOriginMatching(t *testing.T) {
+	t.Run("Subdomain wildcard", func(t *testing.T) {
+		p := newTestPolicy(t, CORSOptions{AllowedOrigins: []string{"https://*.example.com"}})
+		assert.True(t, p.isAllowedOrigin("https://preview-12.example.com", nil), "should be true")
+		assert.True(t, p.isAllowedOrigin("https://a.b.example.com", nil), "should be true")
+		assert.False(t, p.isAllowedOrigin("https://example.com", nil), "should be false")
+		assert.False(t, p.isAllowedOrigin("http://preview.example.com", nil), "should be false")
+		assert.False(t, p.isAllowedOrigin("https://evil.com/.example.com", nil), "should be false")
+		assert.False(t, p.isAllowedOrigin("https://preview.example.com.evil.com", nil), "should be false")
+	})
+
+	t.Run("Wildcard added to default policy", func(t *testing.T) {
+		defer func(p *CORSPolicy) { defaultCORSPolicy = p }(defaultCORSPolicy)
+		defaultCORSPolicy = newTestPolicy(t, CORSOptions{})
+		AllowCORSOrigins("https://*.example.com")
+		assert.True(t, defaultCORSPolicy.isAllowedOrigin("https://www.example.com", nil), "should be true")
+		SetOutput(&bytes.Buffer{})
+		AllowCORSOrigins("https://api.example.org", "https://*.*.example.com")
+		assert.NotNil(t, DefaultCORSPolicyErr(), "should not be nil")
+		assert.False(t, defaultCORSPolicy.isAllowedOrigin("https://api.example.org", nil), "should be unchanged")
+	})
+
+	t.Run("Regular expression", func(t *testing.T) {
+		p := newTestPolicy(t, CORSOptions{AllowedOriginPatterns: []string{`https://pr-[0-9]+\.example\.com`}})
+		assert.True(t, p.isAllowedOrigin("https://pr-42.example.com", nil), "should be true")
+		assert.False(t, p.isAllowedOrigin("https://pr-42.example.com.evil.com", nil), "should be false")
+		assert.False(t, p.isAllowedOrigin("https://pr-x.example.com", nil), "should be false")
+	})
+
+	t.Run("Predicate", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/index", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Tenant", "acme")
+		p := newTestPolicy(t, CORSOptions{AllowOriginFunc: func(origin string, r *http.Request) bool {
+			return origin == "https://acme.test" && r.Header.Get("X-Tenant") == "acme"
+		}})
+		assert.True(t, p.isAllowedOrigin("https://acme.test", req), "should be true")
+		assert.False(t, p.isAllowedOrigin("https://other.test", req), "should be false")
+	})
+
+	t.Run("Malformed configuration", func(t *testing.T) {
+		for _, o := range []string{"*.example.com", "https://*", "https://*example.com", "https://*.*.example.com", "https://www.*.com"} {
+			_, err := NewCORSPolicy(CORSOptions{AllowedOrigins: []string{o}})
+			assert.NotNil(t, err, "should not be nil: %s", o)
+		}
+		_, err := NewCORSPolicy(CORSOptions{AllowedOriginPatterns: []string{"https://(unclosed"}})
+		assert.NotNil(t, err, "should not be nil")
+	})
+}
+
+func TestPrivateNetwork(t *testing.T) {
+	noop := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	req, err := http.NewRequest(http.MethodOptions, "/index", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(corsOrigin, "https://public.example.com")
+	req.Header.Set(corsAccessControlRequestMethod, "GET")
+	req.Header.Set(corsAccessControlRequestPrivateNetwork, "true")
+	opts := CORSOptions{
+		AllowedOrigins: []string{"https://public.example.com"},
+		AllowedMethods: []string{"GET"},
+	}
+
+	t.Run("Allowed", func(t *testing.T) {
+		o := opts
+		o.AllowPrivateNetwork = true
+		rr := httptest.NewRecorder()
+		newTestPolicy(t, o).Handler(noop).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusNoContent, rr.Code, "should be equal")
+		assert.Equal(t, "true", rr.Header().Get(corsAccessControlAllowPrivateNetwork), "should be equal")
+	})
+
+	t.Run("Not allowed", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		newTestPolicy(t, opts).Handler(noop).ServeHTTP(rr, req)
+		assert.Equal(t, "https://public.example.com", rr.Header().Get(corsAccessControlAllowOrigin), "should be equal")
+		assert.Empty(t, rr.Header().Get(corsAccessControlAllowPrivateNetwork), "should be empty")
+	})
+
+	t.Run("Not requested", func(t *testing.T) {
+		o := opts
+		o.AllowPrivateNetwork = true
+		req := req.Clone(req.Context())
+		req.Header.Del(corsAccessControlRequestPrivateNetwork)
+		rr := httptest.NewRecorder()
+		newTestPolicy(t, o).Handler(noop).ServeHTTP(rr, req)
+		assert.Empty(t, rr.Header().Get(corsAccessControlAllowPrivateNetwork), "should be empty")
+	})
 }
 
 func ExampleCORSHandler() {
@@ -146,3 +481,29 @@ func ExampleCORSHandler() {
 	http.Handle("/", corsHandler)
 	http.ListenAndServe(":8080", nil)
 }
+
+func ExampleCORSPolicy() {
+	public, err := NewCORSPolicy(CORSOptions{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{"GET", "HEAD"},
+	})
+	if err != nil {
+		// error handling
+	}
+	admin, err := NewCORSPolicy(CORSOptions{
+		AllowedOrigins:   []string{"https://admin.example.com", "https://*.admin.example.com"},
+		AllowedMethods:   []string{"GET", "POST", "DELETE"},
+		AllowCredentials: true,
+	})
+	if err != nil {
+		// error handling
+	}
+
+	apiHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// ... do something
+	})
+
+	http.Handle("/api/", public.Handler(apiHandler))
+	http.Handle("/admin/", admin.Handler(apiHandler))
+	http.ListenAndServe(":8080", nil)
+}
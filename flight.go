@@ -0,0 +1,61 @@
+package middlewares
+
+import (
+	"context"
+	"sync"
+)
+
+// flight is a call in progress whose result is shared by every caller waiting for it
+type flight struct {
+	done chan struct{}
+	val  interface{}
+	err  error
+}
+
+func newFlight() *flight {
+	return &flight{done: make(chan struct{})}
+}
+
+// finish stores the result of the call and releases the waiting callers
+func (f *flight) finish(val interface{}, err error) {
+	f.val, f.err = val, err
+	close(f.done)
+}
+
+// wait returns the result of the call, or the error of ctx if it's done first
+func (f *flight) wait(ctx context.Context) (interface{}, error) {
+	select {
+	case <-f.done:
+		return f.val, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// flightGroup deduplicates concurrent calls with the same key, like golang.org/x/sync/singleflight. The call runs in its own goroutine, so a caller that gives up doesn't cancel it for the others.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[interface{}]*flight
+}
+
+// do calls fn unless a call with the same key is in progress, and waits for the result
+func (g *flightGroup) do(ctx context.Context, key interface{}, fn func() (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	f, ok := g.flights[key]
+	if !ok {
+		if g.flights == nil {
+			g.flights = map[interface{}]*flight{}
+		}
+		f = newFlight()
+		g.flights[key] = f
+		go func() {
+			val, err := fn()
+			g.mu.Lock()
+			delete(g.flights, key)
+			g.mu.Unlock()
+			f.finish(val, err)
+		}()
+	}
+	g.mu.Unlock()
+	return f.wait(ctx)
+}
@@ -0,0 +1,14 @@
+module github.com/hawry/middlewares
+
+go 1.22
+
+require (
+	github.com/stretchr/testify v1.8.4
+	golang.org/x/crypto v0.9.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
@@ -0,0 +1,165 @@
+package middlewares
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+//HtpasswdFile is an Authenticator backed by an Apache htpasswd file. Entries hashed with bcrypt ($2y$), SHA1 ({SHA}) and APR1-MD5 ($apr1$) are supported. The file is reloaded when it changes on disk.
+type HtpasswdFile struct {
+	file *watchedFile
+}
+
+//NewHtpasswdFile returns an HtpasswdFile reading users from path, or an error if the file can't be read or contains malformed entries
+func NewHtpasswdFile(path string) (*HtpasswdFile, error) {
+	f, err := newWatchedFile(path, func(r io.Reader) (interface{}, error) {
+		return parseHtpasswd(path, r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &HtpasswdFile{file: f}, nil
+}
+
+//Authenticate validates user and pass against the htpasswd file and returns an Identity named user on success
+func (h *HtpasswdFile) Authenticate(user, pass string, r *http.Request) (Principal, error) {
+	hash, ok := h.file.load().(map[string]string)[user]
+	if !ok {
+		// compare against a dummy hash, so that unknown users take as long as known ones
+		htpasswdMatch(dummyBcryptHash(), pass)
+		return nil, errors.New("invalid user or password")
+	}
+	if !htpasswdMatch(hash, pass) {
+		return nil, errors.New("invalid user or password")
+	}
+	return &Identity{Name: user}, nil
+}
+
+// parseHtpasswd returns the password hashes of the htpasswd file path indexed by user
+func parseHtpasswd(path string, r io.Reader) (map[string]string, error) {
+	users := map[string]string{}
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i < 1 || i == len(line)-1 {
+			return nil, fmt.Errorf("malformed htpasswd entry on line %d of %s", n, path)
+		}
+		users[line[:i]] = line[i+1:]
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// dummyBcryptHash returns the bcrypt hash that the passwords of unknown users are compared against, it's generated on first use
+func dummyBcryptHash() string {
+	dummyHashOnce.Do(func() {
+		b, _ := bcrypt.GenerateFromPassword([]byte("unknown user"), bcrypt.DefaultCost)
+		dummyHash = string(b)
+	})
+	return dummyHash
+}
+
+// htpasswdMatch reports whether pass matches the htpasswd hash
+func htpasswdMatch(hash, pass string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2y$"), strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) == nil
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(pass))
+		return subtle.ConstantTimeCompare([]byte(hash[5:]), []byte(base64.StdEncoding.EncodeToString(sum[:]))) == 1
+	case strings.HasPrefix(hash, "$apr1$"):
+		parts := strings.SplitN(hash[6:], "$", 2)
+		if len(parts) != 2 {
+			return false
+		}
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(apr1(pass, parts[0]))) == 1
+	}
+	return false // plaintext and crypt(3) entries aren't supported
+}
+
+const apr1Alphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// apr1 returns the Apache variant of the MD5-based crypt(3) hash of pass, as generated by htpasswd -m
+func apr1(pass, salt string) string {
+	const magic = "$apr1$"
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(pass)
+
+	alt := md5.Sum([]byte(pass + salt + pass))
+	d := md5.New()
+	d.Write([]byte(pass + magic + salt))
+	for i := len(pw); i > 0; i -= 16 {
+		if i > 16 {
+			d.Write(alt[:])
+		} else {
+			d.Write(alt[:i])
+		}
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 == 1 {
+			d.Write([]byte{0})
+		} else {
+			d.Write(pw[:1])
+		}
+	}
+	final := d.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		d := md5.New()
+		if i&1 == 1 {
+			d.Write(pw)
+		} else {
+			d.Write(final)
+		}
+		if i%3 != 0 {
+			d.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			d.Write(pw)
+		}
+		if i&1 == 1 {
+			d.Write(final)
+		} else {
+			d.Write(pw)
+		}
+		final = d.Sum(nil)
+	}
+
+	var b strings.Builder
+	b.WriteString(magic + salt + "$")
+	encode := func(v uint, n int) {
+		for ; n > 0; n-- {
+			b.WriteByte(apr1Alphabet[v&0x3f])
+			v >>= 6
+		}
+	}
+	for _, g := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		encode(uint(final[g[0]])<<16|uint(final[g[1]])<<8|uint(final[g[2]]), 4)
+	}
+	encode(uint(final[11]), 2)
+	return b.String()
+}
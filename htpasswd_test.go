@@ -0,0 +1,127 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// all entries have the password "shardware"
+const testHtpasswd = `# managed by ops
+bcrypt:$2y$04$.xsIEzfrlsOJseCD7iUJ4eEL7udKiCLNhOnkXHtuFPSP4Df.Id8lS
+sha:{SHA}EJg/e95IC0P9Jj/u7vsXC34XBbg=
+apr:$apr1$r31uCDnU$POgJSqpyWO73lHLItiS.x0
+plain:shardware
+`
+
+func writeHtpasswd(t *testing.T, path, content string) {
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestApr1(t *testing.T) {
+	assert.Equal(t, "$apr1$r31uCDnU$POgJSqpyWO73lHLItiS.x0", apr1("shardware", "r31uCDnU"), "should be equal")
+}
+
+func TestHtpasswdFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".htpasswd")
+	writeHtpasswd(t, path, testHtpasswd)
+	h, err := NewHtpasswdFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Supported hashes", func(t *testing.T) {
+		for _, user := range []string{"bcrypt", "sha", "apr"} {
+			p, err := h.Authenticate(user, "shardware", nil)
+			assert.Nil(t, err, "should be nil: %s", user)
+			assert.Equal(t, user, p.Subject(), "should be equal")
+
+			_, err = h.Authenticate(user, "wrong", nil)
+			assert.NotNil(t, err, "should not be nil: %s", user)
+		}
+	})
+
+	t.Run("Unsupported and unknown users", func(t *testing.T) {
+		_, err := h.Authenticate("plain", "shardware", nil)
+		assert.NotNil(t, err, "should not be nil")
+		_, err = h.Authenticate("nobody", "shardware", nil)
+		assert.NotNil(t, err, "should not be nil")
+
+		// unknown users are compared against a bcrypt hash, so they take as long as known ones
+		cost, err := bcrypt.Cost([]byte(dummyBcryptHash()))
+		assert.Nil(t, err, "should be nil")
+		assert.Equal(t, bcrypt.DefaultCost, cost, "should be equal")
+	})
+
+	t.Run("Reloads on change", func(t *testing.T) {
+		writeHtpasswd(t, path, "new:{SHA}EJg/e95IC0P9Jj/u7vsXC34XBbg=\n")
+		later := time.Now().Add(time.Minute)
+		if err := os.Chtimes(path, later, later); err != nil {
+			t.Fatal(err)
+		}
+		h.file.mu.Lock()
+		h.file.lastCheck = time.Time{}
+		h.file.mu.Unlock()
+
+		_, err := h.Authenticate("new", "shardware", nil)
+		assert.Nil(t, err, "should be nil")
+		_, err = h.Authenticate("sha", "shardware", nil)
+		assert.NotNil(t, err, "should not be nil")
+	})
+
+	t.Run("Malformed file", func(t *testing.T) {
+		bad := filepath.Join(t.TempDir(), ".htpasswd")
+		writeHtpasswd(t, bad, "missingcolon\n")
+		_, err := NewHtpasswdFile(bad)
+		assert.NotNil(t, err, "should not be nil")
+
+		_, err = NewHtpasswdFile(filepath.Join(t.TempDir(), "missing"))
+		assert.NotNil(t, err, "should not be nil")
+	})
+}
+
+func TestHtpasswdValidator(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".htpasswd")
+	writeHtpasswd(t, path, testHtpasswd)
+	h, err := NewHtpasswdFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewBasicValidator(BasicAuthOptions{Authenticator: h})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("GET", "/basic", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("apr", "shardware")
+	rr := httptest.NewRecorder()
+	v.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code, "should be equal")
+}
+
+func ExampleHtpasswdFile() {
+	users, err := NewHtpasswdFile("/etc/nginx/.htpasswd")
+	if err != nil {
+		// error handling
+	}
+	v, err := NewBasicValidator(BasicAuthOptions{Realm: "Admin", Authenticator: users})
+	if err != nil {
+		// error handling
+	}
+
+	http.Handle("/", v.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// do something
+	})))
+	http.ListenAndServe(":3000", nil)
+}
@@ -0,0 +1,276 @@
+package middlewares
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultIntrospectionCacheTTL  = 5 * time.Minute
+	defaultIntrospectionCacheSize = 10000
+	defaultIntrospectionTimeout   = 10 * time.Second
+)
+
+// ErrTokenInactive is returned when the introspection endpoint reports a token as inactive
+var ErrTokenInactive = errors.New("token is not active")
+
+//IntrospectionOptions holds the settings used to build an Introspector
+type IntrospectionOptions struct {
+	Endpoint     string        // URL of the RFC 7662 introspection endpoint, required
+	ClientID     string        // client credentials used to authenticate to the endpoint with HTTP Basic
+	ClientSecret string        // secret belonging to ClientID
+	Client       *http.Client  // client used to call the endpoint, defaults to a client with Timeout
+	Timeout      time.Duration // longest time a call to the endpoint may take, defaults to 10 seconds
+	CacheTTL     time.Duration // longest time a result is cached, defaults to 5 minutes. Active results are never cached beyond the token's exp.
+	CacheSize    int           // most results cached, the least recently used are evicted first. Defaults to 10000.
+	Realm        string        // realm sent in the WWW-Authenticate challenge
+}
+
+//Introspector validates opaque bearer tokens using OAuth2 token introspection (RFC 7662). Concurrent lookups of the same token share one call to the endpoint.
+type Introspector struct {
+	endpoint     string
+	clientID     string
+	clientSecret string
+	client       *http.Client
+	timeout      time.Duration
+	cacheTTL     time.Duration
+	cacheSize    int
+	realm        string
+	now          func() time.Time
+	flights      flightGroup
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]*list.Element
+	lru   *list.List // of *introspectionEntry, the most recently used first
+}
+
+type introspectionEntry struct {
+	key     [sha256.Size]byte
+	result  *Introspection // nil if the token is inactive
+	expires time.Time
+}
+
+// response returns the cached result, or ErrTokenInactive
+func (e *introspectionEntry) response() (*Introspection, error) {
+	if e.result == nil {
+		return nil, ErrTokenInactive
+	}
+	return e.result, nil
+}
+
+//Introspection is the response of the introspection endpoint for an active token
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope"`
+	ClientID  string `json:"client_id"`
+	Username  string `json:"username"`
+	TokenType string `json:"token_type"`
+	ExpiresAt int64  `json:"exp"`
+	IssuedAt  int64  `json:"iat"`
+	Subject   string `json:"sub"`
+	Issuer    string `json:"iss"`
+}
+
+//Scopes returns the space separated scope of the token as a list
+func (i *Introspection) Scopes() []string {
+	return strings.Fields(i.Scope)
+}
+
+// principal returns the Identity of an introspected token, the non-empty scope, client_id, username and iss are available as attributes
+func (i *Introspection) principal() *Identity {
+	attrs := map[string]string{}
+	for k, v := range map[string]string{"scope": i.Scope, "client_id": i.ClientID, "username": i.Username, "iss": i.Issuer} {
+		if v != "" {
+			attrs[k] = v
+		}
+	}
+	return &Identity{Name: i.Subject, Method: AuthMethodIntrospection, Scopes: i.Scopes(), Attrs: attrs}
+}
+
+//NewIntrospector returns an Introspector using the settings in opts, or an error if the endpoint is missing or invalid
+func NewIntrospector(opts IntrospectionOptions) (*Introspector, error) {
+	if opts.Endpoint == "" {
+		return nil, errors.New("introspector requires an endpoint")
+	}
+	if _, err := url.ParseRequestURI(opts.Endpoint); err != nil {
+		return nil, err
+	}
+	i := &Introspector{
+		endpoint:     opts.Endpoint,
+		clientID:     opts.ClientID,
+		clientSecret: opts.ClientSecret,
+		client:       opts.Client,
+		timeout:      opts.Timeout,
+		cacheTTL:     opts.CacheTTL,
+		cacheSize:    opts.CacheSize,
+		realm:        opts.Realm,
+		now:          time.Now,
+		cache:        map[[sha256.Size]byte]*list.Element{},
+		lru:          list.New(),
+	}
+	if i.timeout <= 0 {
+		i.timeout = defaultIntrospectionTimeout
+	}
+	if i.client == nil {
+		i.client = &http.Client{Timeout: i.timeout}
+	}
+	if i.cacheTTL <= 0 {
+		i.cacheTTL = defaultIntrospectionCacheTTL
+	}
+	if i.cacheSize <= 0 {
+		i.cacheSize = defaultIntrospectionCacheSize
+	}
+	return i, nil
+}
+
+//Handler returns a http.Handler that introspects the token found by TokenHandler or a TokenReader, or else extracts it like TokenHandler, before calling next. On success the result is available through TokenIntrospection and the subject through PrincipalFrom. Inactive tokens are answered with 401 Unauthorized, and 503 Service Unavailable is returned if the endpoint can't be reached.
+func (i *Introspector) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := requestToken(w, r, i.realm)
+		if !ok {
+			return
+		}
+		res, err := i.Introspect(r.Context(), token)
+		if errors.Is(err, ErrTokenInactive) {
+			i.challenge(w, err)
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(output, "warn: %s\n", err.Error())
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		ctx := context.WithValue(r.Context(), tokenContextKey, token)
+		ctx = context.WithValue(ctx, introspectionContextKey, res)
+		ctx = withPrincipal(ctx, res.principal())
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (i *Introspector) challenge(w http.ResponseWriter, err error) {
+	w.Header().Set("WWW-Authenticate", bearerChallenge(i.realm, "invalid_token", err))
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+//TokenIntrospection returns the introspection result stored by an Introspector, if no result is found it returns an error
+func TokenIntrospection(ctx context.Context) (*Introspection, error) {
+	if res, ok := ctx.Value(introspectionContextKey).(*Introspection); ok {
+		return res, nil
+	}
+	return nil, errors.New("no introspection result found in context")
+}
+
+//Introspect returns the introspection result for token, or ErrTokenInactive if the token isn't active. Results are cached, and concurrent lookups of the same token share one call to the endpoint.
+func (i *Introspector) Introspect(ctx context.Context, token string) (*Introspection, error) {
+	key := sha256.Sum256([]byte(token)) // don't keep the raw tokens in memory
+	if e := i.lookup(key); e != nil {
+		return e.response()
+	}
+	v, err := i.flights.do(ctx, key, func() (interface{}, error) {
+		// the call is shared, so it isn't canceled along with the context of the first caller
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), i.timeout)
+		defer cancel()
+		res, err := i.post(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+		e := i.entry(key, res)
+		i.store(e)
+		return e, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*introspectionEntry).response()
+}
+
+// entry returns the cache entry of res, which is cached until CacheTTL or the token's exp, whichever is first
+func (i *Introspector) entry(key [sha256.Size]byte, res *Introspection) *introspectionEntry {
+	now := i.now()
+	e := &introspectionEntry{key: key, expires: now.Add(i.cacheTTL)}
+	if res.Active {
+		if res.ExpiresAt > 0 {
+			if exp := time.Unix(res.ExpiresAt, 0); exp.Before(e.expires) {
+				e.expires = exp
+			}
+		}
+		if !now.Before(e.expires) {
+			res.Active = false // expired according to its own exp claim
+		} else {
+			e.result = res
+		}
+	}
+	return e
+}
+
+// lookup returns the cached entry of key, or nil if there is none or it has expired
+func (i *Introspector) lookup(key [sha256.Size]byte) *introspectionEntry {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	el, ok := i.cache[key]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*introspectionEntry)
+	if !i.now().Before(e.expires) {
+		i.lru.Remove(el)
+		delete(i.cache, key)
+		return nil
+	}
+	i.lru.MoveToFront(el)
+	return e
+}
+
+// store caches e, evicting the least recently used entries beyond CacheSize
+func (i *Introspector) store(e *introspectionEntry) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if el, ok := i.cache[e.key]; ok {
+		el.Value = e
+		i.lru.MoveToFront(el)
+		return
+	}
+	i.cache[e.key] = i.lru.PushFront(e)
+	for i.lru.Len() > i.cacheSize {
+		el := i.lru.Back()
+		i.lru.Remove(el)
+		delete(i.cache, el.Value.(*introspectionEntry).key)
+	}
+}
+
+func (i *Introspector) post(ctx context.Context, token string) (*Introspection, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, i.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if i.clientID != "" {
+		// RFC 6749 section 2.3.1, the credentials are form encoded before being used as Basic credentials
+		req.SetBasicAuth(url.QueryEscape(i.clientID), url.QueryEscape(i.clientSecret))
+	}
+	res, err := i.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("introspection: unexpected status %d from %s", res.StatusCode, i.endpoint)
+	}
+	result := &Introspection{}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(result); err != nil {
+		return nil, fmt.Errorf("introspection: %s", err.Error())
+	}
+	return result, nil
+}
@@ -0,0 +1,227 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIntrospector(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	hits := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "gateway" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		token := r.PostFormValue("token")
+		hits[token]++
+		switch token {
+		case "active":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"active": true, "scope": "orders:read orders:write", "sub": "tom", "client_id": "spa", "exp": now.Add(time.Minute).Unix(),
+			})
+		case "expired":
+			json.NewEncoder(w).Encode(map[string]interface{}{"active": true, "sub": "tom", "exp": now.Add(-time.Minute).Unix()})
+		case "broken":
+			w.Write([]byte("{"))
+		default:
+			json.NewEncoder(w).Encode(map[string]interface{}{"active": false})
+		}
+	}))
+	defer srv.Close()
+
+	i, err := NewIntrospector(IntrospectionOptions{Endpoint: srv.URL, ClientID: "gateway", ClientSecret: "s3cret", Realm: "api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	i.now = func() time.Time { return now }
+
+	t.Run("Active token is cached until exp", func(t *testing.T) {
+		res, err := i.Introspect(context.Background(), "active")
+		assert.Nil(t, err, "should be nil")
+		assert.Equal(t, []string{"orders:read", "orders:write"}, res.Scopes(), "should be equal")
+		assert.Equal(t, "spa", res.ClientID, "should be equal")
+
+		_, err = i.Introspect(context.Background(), "active")
+		assert.Nil(t, err, "should be nil")
+		assert.Equal(t, 1, hits["active"], "should be equal")
+
+		now = now.Add(2 * time.Minute) // past the token's exp, well within CacheTTL
+		i.Introspect(context.Background(), "active")
+		assert.Equal(t, 2, hits["active"], "should be equal")
+		now = now.Add(-2 * time.Minute)
+	})
+
+	t.Run("Inactive token is cached", func(t *testing.T) {
+		_, err := i.Introspect(context.Background(), "revoked")
+		assert.True(t, errors.Is(err, ErrTokenInactive), "should be true")
+		_, err = i.Introspect(context.Background(), "revoked")
+		assert.True(t, errors.Is(err, ErrTokenInactive), "should be true")
+		assert.Equal(t, 1, hits["revoked"], "should be equal")
+	})
+
+	t.Run("Active token past its exp", func(t *testing.T) {
+		_, err := i.Introspect(context.Background(), "expired")
+		assert.True(t, errors.Is(err, ErrTokenInactive), "should be true")
+	})
+
+	t.Run("Endpoint errors aren't cached", func(t *testing.T) {
+		_, err := i.Introspect(context.Background(), "broken")
+		assert.NotNil(t, err, "should not be nil")
+		i.Introspect(context.Background(), "broken")
+		assert.Equal(t, 2, hits["broken"], "should be equal")
+	})
+
+	t.Run("Handler", func(t *testing.T) {
+		var reached bool
+		h := TokenHandler(i.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reached = true
+			res, err := TokenIntrospection(r.Context())
+			assert.Nil(t, err, "should be nil")
+			assert.Equal(t, "spa", res.ClientID, "should be equal")
+			p, err := PrincipalFrom(r.Context())
+			assert.Nil(t, err, "should be nil")
+			assert.Equal(t, "tom", p.Subject(), "should be equal")
+			assert.Equal(t, AuthMethodIntrospection, p.AuthMethod(), "should be equal")
+			assert.Equal(t, "spa", p.Attributes()["client_id"], "should be equal")
+		})))
+
+		for token, status := range map[string]int{"active": http.StatusOK, "revoked": http.StatusUnauthorized, "broken": http.StatusServiceUnavailable} {
+			reached = false
+			req, err := http.NewRequest("GET", "/orders", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+			rr := httptest.NewRecorder()
+			SetOutput(&bytes.Buffer{})
+			h.ServeHTTP(rr, req)
+			assert.Equal(t, status, rr.Code, "should be equal: %s", token)
+			assert.Equal(t, status == http.StatusOK, reached, "should be equal: %s", token)
+		}
+
+		t.Run("Without TokenHandler", func(t *testing.T) {
+			reached = false
+			req, err := http.NewRequest("GET", "/orders", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", "bearer active")
+			rr := httptest.NewRecorder()
+			i.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { reached = true })).ServeHTTP(rr, req)
+			assert.Equal(t, http.StatusOK, rr.Code, "should be equal")
+			assert.True(t, reached, "should be true")
+		})
+	})
+
+	t.Run("Wrong client credentials", func(t *testing.T) {
+		i, err := NewIntrospector(IntrospectionOptions{Endpoint: srv.URL, ClientID: "gateway", ClientSecret: "wrong"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = i.Introspect(context.Background(), "active")
+		assert.NotNil(t, err, "should not be nil")
+		assert.False(t, errors.Is(err, ErrTokenInactive), "should be false")
+	})
+
+	t.Run("Requires endpoint", func(t *testing.T) {
+		_, err := NewIntrospector(IntrospectionOptions{})
+		assert.NotNil(t, err, "should not be nil")
+	})
+}
+
+func TestIntrospectorLoad(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		hits    = map[string]int{}
+		release = make(chan struct{})
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.PostFormValue("token")
+		mu.Lock()
+		hits[token]++
+		mu.Unlock()
+		if token == "slow" {
+			<-release
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"active": false})
+	}))
+	defer srv.Close()
+	count := func(token string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return hits[token]
+	}
+
+	i, err := NewIntrospector(IntrospectionOptions{Endpoint: srv.URL, CacheSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Concurrent lookups share one call", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for n := 0; n < 10; n++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := i.Introspect(context.Background(), "slow")
+				assert.True(t, errors.Is(err, ErrTokenInactive), "should be true")
+			}()
+		}
+		time.Sleep(50 * time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := i.Introspect(ctx, "slow")
+		assert.True(t, errors.Is(err, context.DeadlineExceeded), "should be true")
+
+		close(release)
+		wg.Wait()
+		assert.Equal(t, 1, count("slow"), "should be equal")
+	})
+
+	t.Run("Cache evicts the least recently used", func(t *testing.T) {
+		i.Introspect(context.Background(), "a")
+		i.Introspect(context.Background(), "b") // evicts slow
+		i.Introspect(context.Background(), "a")
+		i.Introspect(context.Background(), "c") // evicts b
+		assert.Len(t, i.cache, 2, "should be capped")
+
+		i.Introspect(context.Background(), "a")
+		i.Introspect(context.Background(), "b")
+		assert.Equal(t, 1, count("a"), "should be equal")
+		assert.Equal(t, 2, count("b"), "should be equal")
+	})
+}
+
+func ExampleIntrospector() {
+	i, err := NewIntrospector(IntrospectionOptions{
+		Endpoint:     "https://auth.example.com/oauth2/introspect",
+		ClientID:     "gateway",
+		ClientSecret: "secret",
+	})
+	if err != nil {
+		// error handling
+	}
+
+	defaultHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res, err := TokenIntrospection(r.Context())
+		if err != nil {
+			// error handling
+		}
+		_ = res.Scopes()
+	})
+
+	http.Handle("/", TokenHandler(i.Handler(defaultHandler)))
+	http.ListenAndServe(":3000", nil)
+}
@@ -0,0 +1,333 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultJWKSTTL             = time.Hour
+	defaultJWKSRefreshInterval = time.Minute
+	defaultJWKSTimeout         = 10 * time.Second
+)
+
+// errJWKSRefreshLimited is returned by refresh when the key set was loaded less than MinRefreshInterval ago
+var errJWKSRefreshLimited = errors.New("jwks: refresh limited by MinRefreshInterval")
+
+//JWKSOptions holds the settings used to build a JWKS
+type JWKSOptions struct {
+	URL                string        // location of the key set, either URL or File is required
+	File               string        // path of a local key set file
+	Client             *http.Client  // client used to fetch URL, defaults to a client with Timeout
+	Timeout            time.Duration // longest time loading the key set may take, defaults to 10 seconds
+	TTL                time.Duration // how long the key set is cached, defaults to 1 hour. A max-age in the Cache-Control header of the response takes precedence.
+	MinRefreshInterval time.Duration // shortest time between two fetches of the key set, defaults to 1 minute. Limits refreshes caused by tokens with unknown key IDs.
+}
+
+//JWKS is a JWTKeyResolver using a JSON Web Key Set (RFC 7517) loaded from a URL or file. The key set is cached and reloaded when it expires, or when a token refers to an unknown key ID, to follow key rotation. Concurrent reloads are merged into one, and keys that are cached can be resolved while a reload is in progress.
+type JWKS struct {
+	url         string
+	file        string
+	client      *http.Client
+	ttl         time.Duration
+	minInterval time.Duration
+	timeout     time.Duration
+	now         func() time.Time
+
+	mu        sync.Mutex
+	keys      []jwk
+	expires   time.Time
+	lastFetch time.Time
+	loading   *flight // reload in progress, if any
+}
+
+// jwk is a parsed JSON Web Key
+type jwk struct {
+	kid string
+	alg string
+	key interface{}
+}
+
+type rawJWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+//NewJWKS returns a JWKS using the settings in opts. The key set is loaded immediately, an error is returned if it can't be loaded.
+func NewJWKS(opts JWKSOptions) (*JWKS, error) {
+	if (opts.URL == "") == (opts.File == "") {
+		return nil, errors.New("jwks requires either a URL or a file")
+	}
+	j := &JWKS{
+		url:         opts.URL,
+		file:        opts.File,
+		client:      opts.Client,
+		ttl:         opts.TTL,
+		minInterval: opts.MinRefreshInterval,
+		timeout:     opts.Timeout,
+		now:         time.Now,
+	}
+	if j.timeout <= 0 {
+		j.timeout = defaultJWKSTimeout
+	}
+	if j.client == nil {
+		j.client = &http.Client{Timeout: j.timeout}
+	}
+	if j.ttl <= 0 {
+		j.ttl = defaultJWKSTTL
+	}
+	if j.minInterval <= 0 {
+		j.minInterval = defaultJWKSRefreshInterval
+	}
+	if err := j.refresh(context.Background()); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+//ResolveKey returns the key with the ID kid. Tokens without a kid use the first key matching alg. The key set is reloaded if it has expired or if no matching key is found, at most once per MinRefreshInterval.
+func (j *JWKS) ResolveKey(ctx context.Context, alg, kid string) (interface{}, error) {
+	j.mu.Lock()
+	expired := !j.now().Before(j.expires)
+	j.mu.Unlock()
+	if expired {
+		if err := j.refresh(ctx); err != nil && err != errJWKSRefreshLimited {
+			fmt.Fprintf(output, "warn: %s\n", err.Error()) // keep using the cached keys
+		}
+	}
+	if k := j.lookup(alg, kid); k != nil {
+		return k, nil
+	}
+	if err := j.refresh(ctx); err == errJWKSRefreshLimited {
+		return nil, ErrTokenKeyNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if k := j.lookup(alg, kid); k != nil {
+		return k, nil
+	}
+	return nil, ErrTokenKeyNotFound
+}
+
+func (j *JWKS) lookup(alg, kid string) interface{} {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, k := range j.keys {
+		if kid != "" && k.kid != kid {
+			continue
+		}
+		if (k.alg != "" && k.alg != alg) || !keyMatchesAlgorithm(k.key, alg) {
+			continue
+		}
+		return k.key
+	}
+	return nil
+}
+
+func keyMatchesAlgorithm(key interface{}, alg string) bool {
+	switch key.(type) {
+	case []byte:
+		return alg == "HS256"
+	case *rsa.PublicKey:
+		return alg == "RS256"
+	case *ecdsa.PublicKey:
+		return alg == "ES256"
+	case ed25519.PublicKey:
+		return alg == "EdDSA"
+	}
+	return false
+}
+
+// refresh reloads the key set and waits until it's loaded, or until ctx is done. A reload already in progress is joined, otherwise errJWKSRefreshLimited is returned if the last one started less than MinRefreshInterval ago.
+func (j *JWKS) refresh(ctx context.Context) error {
+	j.mu.Lock()
+	f := j.loading
+	if f == nil {
+		if j.now().Sub(j.lastFetch) < j.minInterval {
+			j.mu.Unlock()
+			return errJWKSRefreshLimited
+		}
+		j.lastFetch = j.now()
+		f = newFlight()
+		j.loading = f
+		go j.reload(f, j.lastFetch)
+	}
+	j.mu.Unlock()
+	_, err := f.wait(ctx)
+	return err
+}
+
+// reload loads the key set without holding j.mu, which is only taken to store the keys
+func (j *JWKS) reload(f *flight, started time.Time) {
+	keys, ttl, err := j.load()
+	j.mu.Lock()
+	if err == nil {
+		j.keys = keys
+		j.expires = started.Add(ttl)
+	}
+	j.loading = nil
+	j.mu.Unlock()
+	f.finish(nil, err)
+}
+
+// load reads or fetches the key set and returns it together with its cache lifetime
+func (j *JWKS) load() ([]jwk, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), j.timeout)
+	defer cancel()
+	var (
+		body []byte
+		ttl  = j.ttl
+		err  error
+	)
+	if j.file != "" {
+		body, err = os.ReadFile(j.file)
+	} else {
+		body, ttl, err = j.fetch(ctx)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	keys, err := parseJWKS(body)
+	if err != nil {
+		return nil, 0, err
+	}
+	if ttl < j.minInterval {
+		ttl = j.minInterval
+	}
+	return keys, ttl, nil
+}
+
+// fetch downloads the key set and returns it together with the cache lifetime given by the response
+func (j *JWKS) fetch(ctx context.Context) (body []byte, ttl time.Duration, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := j.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("jwks: unexpected status %d from %s", res.StatusCode, j.url)
+	}
+	body, err = io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, 0, err
+	}
+	return body, cacheControlTTL(res.Header.Get("Cache-Control"), j.ttl), nil
+}
+
+// cacheControlTTL returns the lifetime given by a Cache-Control header, or def if the header doesn't specify one
+func cacheControlTTL(cc string, def time.Duration) time.Duration {
+	for _, d := range strings.Split(cc, ",") {
+		d = strings.ToLower(strings.TrimSpace(d))
+		switch {
+		case d == "no-store", d == "no-cache":
+			return 0
+		case strings.HasPrefix(d, "max-age="):
+			if s, err := strconv.Atoi(d[len("max-age="):]); err == nil && s >= 0 {
+				return time.Duration(s) * time.Second
+			}
+		}
+	}
+	return def
+}
+
+func parseJWKS(b []byte) (keys []jwk, err error) {
+	var set struct {
+		Keys []rawJWK `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, fmt.Errorf("jwks: %s", err.Error())
+	}
+	for _, rk := range set.Keys {
+		if rk.Use != "" && rk.Use != "sig" {
+			continue
+		}
+		k, err := rk.parse()
+		if err != nil {
+			return nil, fmt.Errorf("jwks: key %q: %s", rk.Kid, err.Error())
+		}
+		if k != nil {
+			keys = append(keys, jwk{kid: rk.Kid, alg: rk.Alg, key: k})
+		}
+	}
+	return keys, nil
+}
+
+// parse returns the key in the format expected by JWTValidator, unsupported key types return a nil key
+func (rk rawJWK) parse() (interface{}, error) {
+	b64 := base64.RawURLEncoding
+	switch rk.Kty {
+	case "RSA":
+		n, err := b64.DecodeString(rk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64.DecodeString(rk.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if rk.Crv != "P-256" {
+			return nil, nil
+		}
+		x, err := b64.DecodeString(rk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64.DecodeString(rk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("invalid P-256 coordinates")
+		}
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, err // not a point on the curve
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if rk.Crv != "Ed25519" {
+			return nil, nil
+		}
+		x, err := b64.DecodeString(rk.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	case "oct":
+		return b64.DecodeString(rk.K)
+	}
+	return nil, nil
+}
@@ -0,0 +1,276 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testJWK returns the public JWK representation of a private key
+func testJWK(kid string, key interface{}) map[string]string {
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return map[string]string{"kty": "RSA", "kid": kid, "n": b64(k.N.Bytes()), "e": b64(big.NewInt(int64(k.E)).Bytes())}
+	case *ecdsa.PrivateKey:
+		x, y := make([]byte, 32), make([]byte, 32)
+		k.X.FillBytes(x)
+		k.Y.FillBytes(y)
+		return map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": b64(x), "y": b64(y)}
+	case ed25519.PrivateKey:
+		return map[string]string{"kty": "OKP", "kid": kid, "crv": "Ed25519", "x": b64(k.Public().(ed25519.PublicKey))}
+	}
+	return nil
+}
+
+// testJWKSServer serves a key set that can be swapped while the server is running
+type testJWKSServer struct {
+	mu           sync.Mutex
+	keys         []map[string]string
+	cacheControl string
+	hits         int
+	release      chan struct{} // if set, responses wait until it's closed
+	status       int           // if set, responses fail with this status
+}
+
+func (s *testJWKSServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	release := s.release
+	s.mu.Unlock()
+	if release != nil {
+		<-release
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hits++
+	if s.status != 0 {
+		http.Error(w, http.StatusText(s.status), s.status)
+		return
+	}
+	if s.cacheControl != "" {
+		w.Header().Set("Cache-Control", s.cacheControl)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": s.keys})
+}
+
+func (s *testJWKSServer) set(keys ...map[string]string) {
+	s.mu.Lock()
+	s.keys = keys
+	s.mu.Unlock()
+}
+
+func TestJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	js := &testJWKSServer{}
+	js.set(testJWK("rs-1", rsaKey), testJWK("es-1", ecKey))
+	srv := httptest.NewServer(js)
+	defer srv.Close()
+
+	now := time.Unix(1700000000, 0)
+	keys, err := NewJWKS(JWKSOptions{URL: srv.URL, TTL: time.Hour, MinRefreshInterval: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys.now = func() time.Time { return now }
+	keys.lastFetch, keys.expires = now, now.Add(time.Hour)
+
+	v, err := NewJWTValidator(JWTOptions{Keys: keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := map[string]interface{}{"sub": "tom"}
+
+	t.Run("Selects key by kid", func(t *testing.T) {
+		_, err := v.Verify(context.Background(), signTestJWT(t, "RS256", "rs-1", rsaKey, claims))
+		assert.Nil(t, err, "should be nil")
+		_, err = v.Verify(context.Background(), signTestJWT(t, "ES256", "es-1", ecKey, claims))
+		assert.Nil(t, err, "should be nil")
+		assert.Equal(t, 1, js.hits, "should be equal")
+	})
+
+	t.Run("Selects key by algorithm without kid", func(t *testing.T) {
+		_, err := v.Verify(context.Background(), signTestJWT(t, "ES256", "", ecKey, claims))
+		assert.Nil(t, err, "should be nil")
+	})
+
+	t.Run("Unknown kid is rate limited", func(t *testing.T) {
+		js.set(testJWK("rs-1", rsaKey), testJWK("es-1", ecKey), testJWK("ed-1", edKey))
+		_, err := v.Verify(context.Background(), signTestJWT(t, "EdDSA", "ed-1", edKey, claims))
+		assert.True(t, errors.Is(err, ErrTokenKeyNotFound), "should be true")
+		assert.Equal(t, 1, js.hits, "should be equal")
+	})
+
+	t.Run("Unknown kid refreshes after the interval", func(t *testing.T) {
+		now = now.Add(2 * time.Minute)
+		_, err := v.Verify(context.Background(), signTestJWT(t, "EdDSA", "ed-1", edKey, claims))
+		assert.Nil(t, err, "should be nil")
+		assert.Equal(t, 2, js.hits, "should be equal")
+	})
+
+	t.Run("Rotated keys are dropped when the cache expires", func(t *testing.T) {
+		js.set(testJWK("ed-1", edKey))
+		now = now.Add(2 * time.Hour)
+		_, err := v.Verify(context.Background(), signTestJWT(t, "RS256", "rs-1", rsaKey, claims))
+		assert.True(t, errors.Is(err, ErrTokenKeyNotFound), "should be true")
+		assert.Equal(t, 3, js.hits, "should be equal")
+	})
+
+	t.Run("Respects Cache-Control", func(t *testing.T) {
+		js.cacheControl = "public, max-age=300"
+		now = now.Add(2 * time.Hour)
+		_, err := keys.ResolveKey(context.Background(), "EdDSA", "ed-1")
+		assert.Nil(t, err, "should be nil")
+		assert.Equal(t, now.Add(5*time.Minute), keys.expires, "should be equal")
+
+		assert.Equal(t, time.Duration(0), cacheControlTTL("no-store", time.Hour), "should be equal")
+		assert.Equal(t, time.Hour, cacheControlTTL("", time.Hour), "should be equal")
+	})
+
+	t.Run("Concurrent refreshes are merged and don't block cached keys", func(t *testing.T) {
+		release := make(chan struct{})
+		js.mu.Lock()
+		js.release, js.hits = release, 0
+		js.mu.Unlock()
+		now = now.Add(2 * time.Minute)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				keys.ResolveKey(context.Background(), "RS256", "rs-2")
+			}()
+		}
+		time.Sleep(50 * time.Millisecond) // let the lookups start the refresh
+
+		_, err := keys.ResolveKey(context.Background(), "EdDSA", "ed-1")
+		assert.Nil(t, err, "should be nil: cached key")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err = keys.ResolveKey(ctx, "RS256", "rs-3")
+		assert.True(t, errors.Is(err, context.DeadlineExceeded), "should be true")
+
+		close(release)
+		wg.Wait()
+		js.mu.Lock()
+		assert.Equal(t, 1, js.hits, "should be equal")
+		js.release = nil
+		js.mu.Unlock()
+	})
+
+	t.Run("Fetch failures are answered with 503", func(t *testing.T) {
+		js.mu.Lock()
+		js.status = http.StatusInternalServerError
+		js.mu.Unlock()
+		defer func() {
+			js.mu.Lock()
+			js.status = 0
+			js.mu.Unlock()
+		}()
+		now = now.Add(2 * time.Minute)
+
+		v, err := NewJWTValidator(JWTOptions{Keys: keys})
+		if err != nil {
+			t.Fatal(err)
+		}
+		v.now = func() time.Time { return now }
+		var b bytes.Buffer
+		SetOutput(&b)
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+signTestJWT(t, "RS256", "rs-9", rsaKey, map[string]interface{}{"sub": "tom"}))
+		rr := httptest.NewRecorder()
+		v.Handler(http.NotFoundHandler()).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code, "should be equal")
+		assert.Empty(t, rr.Header().Get("WWW-Authenticate"), "should be empty")
+		assert.NotContains(t, rr.Body.String(), srv.URL, "should not disclose the key set URL")
+		assert.Contains(t, b.String(), "unexpected status 500", "should be logged")
+
+		_, err = v.Verify(context.Background(), signTestJWT(t, "RS256", "rs-9", rsaKey, nil))
+		assert.True(t, errors.Is(err, ErrTokenKeyNotFound), "should be true: refresh is rate limited")
+	})
+}
+
+func TestJWKSFile(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	json.NewEncoder(&b).Encode(map[string]interface{}{"keys": []map[string]string{
+		testJWK("es-1", ecKey),
+		{"kty": "RSA", "kid": "enc-1", "use": "enc", "n": "AQAB", "e": "AQAB"},
+	}})
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, b.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := NewJWKS(JWKSOptions{File: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := keys.ResolveKey(context.Background(), "ES256", "es-1")
+	assert.Nil(t, err, "should be nil")
+	assert.Equal(t, &ecKey.PublicKey, k, "should be equal")
+	_, err = keys.ResolveKey(context.Background(), "RS256", "enc-1")
+	assert.NotNil(t, err, "should not be nil")
+
+	t.Run("Invalid configuration", func(t *testing.T) {
+		_, err := NewJWKS(JWKSOptions{})
+		assert.NotNil(t, err, "should not be nil")
+		_, err = NewJWKS(JWKSOptions{File: filepath.Join(t.TempDir(), "missing.json")})
+		assert.NotNil(t, err, "should not be nil")
+	})
+
+	t.Run("Point not on curve", func(t *testing.T) {
+		_, err := parseJWKS([]byte(`{"keys":[{"kty":"EC","crv":"P-256","x":"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA","y":"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAE"}]}`))
+		assert.NotNil(t, err, "should not be nil")
+	})
+}
+
+func ExampleJWKS() {
+	keys, err := NewJWKS(JWKSOptions{URL: "https://issuer.example.com/.well-known/jwks.json"})
+	if err != nil {
+		// error handling
+	}
+	v, err := NewJWTValidator(JWTOptions{Keys: keys, Issuer: "https://issuer.example.com"})
+	if err != nil {
+		// error handling
+	}
+
+	http.Handle("/", v.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// do something
+	})))
+	http.ListenAndServe(":3000", nil)
+}
@@ -0,0 +1,412 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Errors returned when a JSON Web Token fails verification, use errors.Is to check for them
+var (
+	ErrTokenMalformed    = errors.New("malformed token")
+	ErrTokenAlgorithm    = errors.New("token algorithm not allowed")
+	ErrTokenSignature    = errors.New("invalid token signature")
+	ErrTokenExpired      = errors.New("token has expired")
+	ErrTokenNotValidYet  = errors.New("token is not valid yet")
+	ErrTokenIssuer       = errors.New("invalid token issuer")
+	ErrTokenAudience     = errors.New("invalid token audience")
+	ErrTokenKeyNotFound  = errors.New("no key found for token")
+	ErrTokenMissingClaim = errors.New("token is missing a required claim")
+)
+
+// tokenErrors are the errors described in the challenge of JWTValidator, other errors get the description of errTokenInvalid
+var tokenErrors = []error{ErrTokenMalformed, ErrTokenAlgorithm, ErrTokenSignature, ErrTokenExpired, ErrTokenNotValidYet, ErrTokenIssuer, ErrTokenAudience, ErrTokenKeyNotFound, ErrTokenMissingClaim}
+
+var errTokenInvalid = errors.New("invalid token")
+
+//KeyResolverError is returned when the JWTKeyResolver fails for another reason than an unknown key, e.g. when the key set can't be fetched. It's answered with 503 Service Unavailable instead of a challenge, as the token may well be valid.
+type KeyResolverError struct {
+	Err error
+}
+
+func (e *KeyResolverError) Error() string {
+	return "resolving token key: " + e.Err.Error()
+}
+
+//Unwrap returns the error of the key resolver
+func (e *KeyResolverError) Unwrap() error {
+	return e.Err
+}
+
+// supportedJWTAlgorithms are the signing algorithms understood by JWTValidator
+var supportedJWTAlgorithms = []string{"HS256", "RS256", "ES256", "EdDSA"}
+
+//JWTKeyResolver returns the key used to verify a token signed with alg. kid is the key ID from the token header, or an empty string if the token has none.
+//
+// Keys are []byte for HS256, *rsa.PublicKey for RS256, *ecdsa.PublicKey for ES256 and ed25519.PublicKey for EdDSA.
+type JWTKeyResolver interface {
+	ResolveKey(ctx context.Context, alg, kid string) (interface{}, error)
+}
+
+//StaticKeys is a JWTKeyResolver using a fixed set of keys indexed by key ID. The key stored under "" is used for tokens without a kid.
+type StaticKeys map[string]interface{}
+
+//ResolveKey returns the key stored under kid
+func (s StaticKeys) ResolveKey(ctx context.Context, alg, kid string) (interface{}, error) {
+	if k, ok := s[kid]; ok {
+		return k, nil
+	}
+	return nil, ErrTokenKeyNotFound
+}
+
+//JWTOptions holds the settings used to build a JWTValidator
+type JWTOptions struct {
+	Keys          JWTKeyResolver // resolves the verification keys, required
+	Algorithms    []string       // allowed signing algorithms, defaults to HS256, RS256, ES256 and EdDSA. "none" is never allowed.
+	Issuer        string         // required value of the iss claim, not checked if empty
+	Audience      string         // value that must be present in the aud claim, not checked if empty
+	ClockSkew     time.Duration  // leeway used when checking exp, nbf and iat
+	RequireExpiry bool           // rejects tokens without an exp claim
+	Realm         string         // realm sent in the WWW-Authenticate challenge
+}
+
+//JWTValidator verifies JSON Web Tokens sent as Bearer tokens
+type JWTValidator struct {
+	keys          JWTKeyResolver
+	algorithms    map[string]bool
+	issuer        string
+	audience      string
+	clockSkew     time.Duration
+	requireExpiry bool
+	realm         string
+	now           func() time.Time
+}
+
+//Claims holds the registered claims of a verified token, all claims (including the registered ones) are available in Raw
+type Claims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ExpiresAt time.Time // zero if the token has no exp claim
+	NotBefore time.Time // zero if the token has no nbf claim
+	IssuedAt  time.Time // zero if the token has no iat claim
+	ID        string
+	Raw       map[string]interface{}
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+//NewJWTValidator returns a JWTValidator using the settings in opts, or an error if no key resolver is given or an algorithm isn't supported
+func NewJWTValidator(opts JWTOptions) (*JWTValidator, error) {
+	if opts.Keys == nil {
+		return nil, errors.New("jwt validator requires a key resolver")
+	}
+	v := &JWTValidator{
+		keys:          opts.Keys,
+		algorithms:    map[string]bool{},
+		issuer:        opts.Issuer,
+		audience:      opts.Audience,
+		clockSkew:     opts.ClockSkew,
+		requireExpiry: opts.RequireExpiry,
+		realm:         opts.Realm,
+		now:           time.Now,
+	}
+	algs := opts.Algorithms
+	if len(algs) == 0 {
+		algs = supportedJWTAlgorithms
+	}
+	for _, alg := range algs {
+		if !isSupportedAlgorithm(alg) {
+			return nil, fmt.Errorf("unsupported jwt algorithm %q", alg)
+		}
+		v.algorithms[alg] = true
+	}
+	return v, nil
+}
+
+func isSupportedAlgorithm(alg string) bool {
+	for _, a := range supportedJWTAlgorithms {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+//Handler returns a http.Handler that verifies the token found by TokenHandler or a TokenReader, or else extracts it like TokenHandler, before calling next. On success the raw token is available through Token, the claims through JWTClaims and the subject through PrincipalFrom. Requests without a valid token are answered with 401 Unauthorized and a RFC 6750 WWW-Authenticate challenge, and 503 Service Unavailable is returned if the key resolver fails.
+func (v *JWTValidator) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := requestToken(w, r, v.realm)
+		if !ok {
+			return
+		}
+		claims, err := v.Verify(r.Context(), token)
+		var kerr *KeyResolverError
+		if errors.As(err, &kerr) {
+			fmt.Fprintf(output, "warn: %s\n", err.Error())
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			v.challenge(w, err)
+			return
+		}
+		ctx := context.WithValue(r.Context(), tokenContextKey, token)
+		ctx = context.WithValue(ctx, claimsContextKey, claims)
+		ctx = withPrincipal(ctx, &Identity{
+			Name:      claims.Subject,
+			Method:    AuthMethodJWT,
+			Scopes:    claims.Scopes(),
+			Roles:     claims.stringList("roles"),
+			RawClaims: claims.Raw,
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// challenge answers the request with 401 Unauthorized, err is described in the challenge if it isn't nil. Only the ErrToken* errors are described, so that details of other errors, such as the type of a key, aren't disclosed.
+func (v *JWTValidator) challenge(w http.ResponseWriter, err error) {
+	if err != nil {
+		public := errTokenInvalid
+		for _, e := range tokenErrors {
+			if errors.Is(err, e) {
+				public = e
+				break
+			}
+		}
+		err = public
+	}
+	w.Header().Set("WWW-Authenticate", bearerChallenge(v.realm, "invalid_token", err))
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+//JWTClaims returns the claims of the token verified by a JWTValidator, if no claims are found it returns an error
+func JWTClaims(ctx context.Context) (*Claims, error) {
+	if c, ok := ctx.Value(claimsContextKey).(*Claims); ok {
+		return c, nil
+	}
+	return nil, errors.New("no claims found in context")
+}
+
+//Verify checks the signature and claims of token and returns the claims if the token is valid. Errors of the key resolver other than ErrTokenKeyNotFound are returned as a *KeyResolverError.
+func (v *JWTValidator) Verify(ctx context.Context, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenMalformed
+	}
+	var h jwtHeader
+	if err := decodeJWTPart(parts[0], &h); err != nil {
+		return nil, err
+	}
+	if !v.algorithms[h.Alg] {
+		return nil, ErrTokenAlgorithm // also rejects "none"
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	key, err := v.keys.ResolveKey(ctx, h.Alg, h.Kid)
+	if errors.Is(err, ErrTokenKeyNotFound) {
+		return nil, err
+	} else if err != nil {
+		return nil, &KeyResolverError{Err: err}
+	}
+	if err := verifyJWTSignature(h.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	claims := &Claims{}
+	if err := decodeJWTPart(parts[1], &claims.Raw); err != nil {
+		return nil, err
+	}
+	if err := claims.parse(); err != nil {
+		return nil, err
+	}
+	if err := v.validate(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return ErrTokenMalformed
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(v); err != nil {
+		return ErrTokenMalformed
+	}
+	return nil
+}
+
+// verifyJWTSignature checks sig against signed, the key type must match alg to prevent algorithm confusion
+func verifyJWTSignature(alg string, key interface{}, signed, sig []byte) error {
+	sum := sha256.Sum256(signed)
+	switch alg {
+	case "HS256":
+		k, ok := key.([]byte)
+		if !ok {
+			break
+		}
+		mac := hmac.New(sha256.New, k)
+		mac.Write(signed)
+		if hmac.Equal(sig, mac.Sum(nil)) {
+			return nil
+		}
+		return ErrTokenSignature
+	case "RS256":
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			break
+		}
+		if rsa.VerifyPKCS1v15(k, crypto.SHA256, sum[:], sig) == nil {
+			return nil
+		}
+		return ErrTokenSignature
+	case "ES256":
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok || k.Curve != elliptic.P256() {
+			break
+		}
+		if len(sig) == 64 && ecdsa.Verify(k, sum[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+			return nil
+		}
+		return ErrTokenSignature
+	case "EdDSA":
+		k, ok := key.(ed25519.PublicKey)
+		if !ok {
+			break
+		}
+		if len(k) == ed25519.PublicKeySize && ed25519.Verify(k, signed, sig) {
+			return nil
+		}
+		return ErrTokenSignature
+	}
+	return fmt.Errorf("%w: key of type %T can't verify %s", ErrTokenSignature, key, alg)
+}
+
+//Scopes returns the OAuth2 scopes of the token, read from the space separated scope claim or the scp claim
+func (c *Claims) Scopes() []string {
+	if s, ok := c.Raw["scope"].(string); ok {
+		return strings.Fields(s)
+	}
+	return c.stringList("scp")
+}
+
+// stringList returns a claim that's either a list of strings or a space separated string
+func (c *Claims) stringList(name string) (list []string) {
+	switch v := c.Raw[name].(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				list = append(list, s)
+			}
+		}
+	}
+	return list
+}
+
+// parse fills the registered claims from Raw
+func (c *Claims) parse() (err error) {
+	c.Issuer, _ = c.Raw["iss"].(string)
+	c.Subject, _ = c.Raw["sub"].(string)
+	c.ID, _ = c.Raw["jti"].(string)
+	switch aud := c.Raw["aud"].(type) {
+	case string:
+		c.Audience = []string{aud}
+	case []interface{}:
+		for _, a := range aud {
+			s, ok := a.(string)
+			if !ok {
+				return ErrTokenMalformed
+			}
+			c.Audience = append(c.Audience, s)
+		}
+	case nil:
+	default:
+		return ErrTokenMalformed
+	}
+	if c.ExpiresAt, err = numericDate(c.Raw["exp"]); err != nil {
+		return err
+	}
+	if c.NotBefore, err = numericDate(c.Raw["nbf"]); err != nil {
+		return err
+	}
+	c.IssuedAt, err = numericDate(c.Raw["iat"])
+	return err
+}
+
+// maxNumericDate bounds the NumericDates accepted, up to it seconds are represented exactly by a float64
+const maxNumericDate = 1 << 53
+
+// numericDate converts a JWT NumericDate (seconds since the epoch) to a time.Time, nil returns the zero time
+func numericDate(v interface{}) (time.Time, error) {
+	if v == nil {
+		return time.Time{}, nil
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, ErrTokenMalformed
+	}
+	f, err := n.Float64()
+	if err != nil || !(math.Abs(f) < maxNumericDate) {
+		return time.Time{}, ErrTokenMalformed
+	}
+	sec, frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(frac*float64(time.Second))), nil
+}
+
+// validate checks the time based claims, issuer and audience
+func (v *JWTValidator) validate(c *Claims) error {
+	now := v.now()
+	if c.ExpiresAt.IsZero() && v.requireExpiry {
+		return fmt.Errorf("%w: exp", ErrTokenMissingClaim)
+	}
+	if !c.ExpiresAt.IsZero() && !now.Before(c.ExpiresAt.Add(v.clockSkew)) {
+		return ErrTokenExpired
+	}
+	if !c.NotBefore.IsZero() && now.Add(v.clockSkew).Before(c.NotBefore) {
+		return ErrTokenNotValidYet
+	}
+	if !c.IssuedAt.IsZero() && now.Add(v.clockSkew).Before(c.IssuedAt) {
+		return ErrTokenNotValidYet
+	}
+	if v.issuer != "" && c.Issuer != v.issuer {
+		return ErrTokenIssuer
+	}
+	if v.audience != "" && !containsString(c.Audience, v.audience) {
+		return ErrTokenAudience
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
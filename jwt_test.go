@@ -0,0 +1,351 @@
+package middlewares
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// signTestJWT returns a token with the given header and claims, signed with key using alg
+func signTestJWT(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	h := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		h["kid"] = kid
+	}
+	hb, _ := json.Marshal(h)
+	cb, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(hb) + "." + base64.RawURLEncoding.EncodeToString(cb)
+	sum := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		s, err := rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = s
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(signed))
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWTSignatures(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("thisisasecret")
+
+	v, err := NewJWTValidator(JWTOptions{Keys: StaticKeys{
+		"hs": secret,
+		"rs": &rsaKey.PublicKey,
+		"es": &ecKey.PublicKey,
+		"ed": edPub,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := map[string]interface{}{"sub": "tom"}
+
+	for _, tc := range []struct {
+		alg, kid string
+		key      interface{}
+	}{
+		{"HS256", "hs", secret},
+		{"RS256", "rs", rsaKey},
+		{"ES256", "es", ecKey},
+		{"EdDSA", "ed", edKey},
+	} {
+		t.Run(tc.alg, func(t *testing.T) {
+			token := signTestJWT(t, tc.alg, tc.kid, tc.key, claims)
+			c, err := v.Verify(context.Background(), token)
+			assert.Nil(t, err, "should be nil")
+			assert.Equal(t, "tom", c.Subject, "should be equal")
+
+			tampered := token[:len(token)-4] + "AAAA"
+			_, err = v.Verify(context.Background(), tampered)
+			assert.True(t, errors.Is(err, ErrTokenSignature), "should be true")
+		})
+	}
+
+	t.Run("Rejects alg none", func(t *testing.T) {
+		token := signTestJWT(t, "none", "hs", nil, claims)
+		_, err := v.Verify(context.Background(), token)
+		assert.True(t, errors.Is(err, ErrTokenAlgorithm), "should be true")
+	})
+
+	t.Run("Rejects algorithm confusion", func(t *testing.T) {
+		// HS256 signed with the bytes of a key that's meant for RS256
+		token := signTestJWT(t, "HS256", "rs", []byte("public key bytes"), claims)
+		_, err := v.Verify(context.Background(), token)
+		assert.True(t, errors.Is(err, ErrTokenSignature), "should be true")
+	})
+
+	t.Run("Unknown key", func(t *testing.T) {
+		token := signTestJWT(t, "HS256", "unknown", secret, claims)
+		_, err := v.Verify(context.Background(), token)
+		assert.True(t, errors.Is(err, ErrTokenKeyNotFound), "should be true")
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		for _, token := range []string{"", "a.b", "a.b.c", "!!!.e30.e30"} {
+			_, err := v.Verify(context.Background(), token)
+			assert.NotNil(t, err, "should not be nil: %s", token)
+		}
+	})
+
+	t.Run("Unsupported algorithm option", func(t *testing.T) {
+		_, err := NewJWTValidator(JWTOptions{Keys: StaticKeys{}, Algorithms: []string{"none"}})
+		assert.NotNil(t, err, "should not be nil")
+	})
+}
+
+func TestJWTClaims(t *testing.T) {
+	secret := []byte("thisisasecret")
+	now := time.Unix(1700000000, 0)
+	v, err := NewJWTValidator(JWTOptions{
+		Keys:      StaticKeys{"": secret},
+		Issuer:    "https://issuer.example.com",
+		Audience:  "orders",
+		ClockSkew: 30 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.now = func() time.Time { return now }
+
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss": "https://issuer.example.com",
+			"sub": "tom",
+			"aud": []string{"billing", "orders"},
+			"exp": now.Add(time.Minute).Unix(),
+			"nbf": now.Unix(),
+			"iat": now.Unix(),
+		}
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		c, err := v.Verify(context.Background(), signTestJWT(t, "HS256", "", secret, valid()))
+		assert.Nil(t, err, "should be nil")
+		assert.Equal(t, []string{"billing", "orders"}, c.Audience, "should be equal")
+		assert.Equal(t, now.Add(time.Minute).Unix(), c.ExpiresAt.Unix(), "should be equal")
+	})
+
+	for name, tc := range map[string]struct {
+		claim string
+		value interface{}
+		err   error
+	}{
+		"Expired":              {"exp", now.Add(-time.Minute).Unix(), ErrTokenExpired},
+		"Not valid yet":        {"nbf", now.Add(time.Minute).Unix(), ErrTokenNotValidYet},
+		"Issued in future":     {"iat", now.Add(time.Minute).Unix(), ErrTokenNotValidYet},
+		"Wrong issuer":         {"iss", "https://evil.example.com", ErrTokenIssuer},
+		"Wrong audience":       {"aud", "billing", ErrTokenAudience},
+		"Malformed timestamp":  {"exp", "tomorrow", ErrTokenMalformed},
+		"Out of range":         {"exp", 1e300, ErrTokenMalformed},
+		"Not valid until 5138": {"nbf", int64(100000000000), ErrTokenNotValidYet},
+		"Issued in 5138":       {"iat", int64(100000000000), ErrTokenNotValidYet},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := valid()
+			c[tc.claim] = tc.value
+			_, err := v.Verify(context.Background(), signTestJWT(t, "HS256", "", secret, c))
+			assert.True(t, errors.Is(err, tc.err), "should be true: %v", err)
+		})
+	}
+
+	t.Run("Within clock skew", func(t *testing.T) {
+		c := valid()
+		c["exp"] = now.Add(-10 * time.Second).Unix()
+		c["nbf"] = now.Add(10 * time.Second).Unix()
+		_, err := v.Verify(context.Background(), signTestJWT(t, "HS256", "", secret, c))
+		assert.Nil(t, err, "should be nil")
+	})
+
+	t.Run("Far future and fractional dates", func(t *testing.T) {
+		c := valid()
+		c["exp"] = int64(100000000000)
+		claims, err := v.Verify(context.Background(), signTestJWT(t, "HS256", "", secret, c))
+		assert.Nil(t, err, "should be nil")
+		assert.Equal(t, 5138, claims.ExpiresAt.Year(), "should be equal")
+
+		c["exp"] = 1700000060.5
+		claims, err = v.Verify(context.Background(), signTestJWT(t, "HS256", "", secret, c))
+		assert.Nil(t, err, "should be nil")
+		assert.Equal(t, 500*time.Millisecond, claims.ExpiresAt.Sub(time.Unix(1700000060, 0)), "should be equal")
+	})
+
+	t.Run("Require expiry", func(t *testing.T) {
+		v, err := NewJWTValidator(JWTOptions{Keys: StaticKeys{"": secret}, RequireExpiry: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = v.Verify(context.Background(), signTestJWT(t, "HS256", "", secret, map[string]interface{}{"sub": "tom"}))
+		assert.True(t, errors.Is(err, ErrTokenMissingClaim), "should be true")
+	})
+}
+
+func TestJWTHandler(t *testing.T) {
+	secret := []byte("thisisasecret")
+	v, err := NewJWTValidator(JWTOptions{Keys: StaticKeys{"": secret}, Realm: "api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := signTestJWT(t, "HS256", "", secret, map[string]interface{}{"sub": "tom", "scope": "orders:read"})
+
+	var reached bool
+	ctxHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+		raw, err := Token(r.Context())
+		assert.Nil(t, err, "should be nil")
+		assert.Equal(t, token, raw, "should be equal")
+		c, err := JWTClaims(r.Context())
+		assert.Nil(t, err, "should be nil")
+		assert.Equal(t, "orders:read", c.Raw["scope"], "should be equal")
+		p, err := PrincipalFrom(r.Context())
+		assert.Nil(t, err, "should be nil")
+		assert.Equal(t, "tom", p.Subject(), "should be equal")
+	})
+
+	t.Run("Valid token", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/tokens", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		rr := httptest.NewRecorder()
+		v.Handler(ctxHandler).ServeHTTP(rr, req)
+		assert.True(t, reached, "should be true")
+		assert.Equal(t, http.StatusOK, rr.Code, "should be equal")
+	})
+
+	t.Run("Missing token", func(t *testing.T) {
+		reached = false
+		req, err := http.NewRequest("GET", "/tokens", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		v.Handler(ctxHandler).ServeHTTP(rr, req)
+		assert.False(t, reached, "should be false")
+		assert.Equal(t, http.StatusUnauthorized, rr.Code, "should be equal")
+		assert.Equal(t, `Bearer realm="api"`, rr.Header().Get("WWW-Authenticate"), "should be equal")
+	})
+
+	t.Run("Invalid token", func(t *testing.T) {
+		reached = false
+		req, err := http.NewRequest("GET", "/tokens", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token[:len(token)-2])
+		rr := httptest.NewRecorder()
+		v.Handler(ctxHandler).ServeHTTP(rr, req)
+		assert.False(t, reached, "should be false")
+		assert.Equal(t, http.StatusUnauthorized, rr.Code, "should be equal")
+		assert.Contains(t, rr.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+	})
+
+	t.Run("Token extracted like TokenHandler", func(t *testing.T) {
+		reached = false
+		req, err := http.NewRequest("GET", "/tokens", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "bearer "+token)
+		rr := httptest.NewRecorder()
+		v.Handler(ctxHandler).ServeHTTP(rr, req)
+		assert.True(t, reached, "should be true")
+		assert.Equal(t, http.StatusOK, rr.Code, "should be equal")
+
+		reached = false
+		req.Header.Add("Authorization", "Bearer "+token)
+		rr = httptest.NewRecorder()
+		v.Handler(ctxHandler).ServeHTTP(rr, req)
+		assert.False(t, reached, "should be false")
+		assert.Equal(t, http.StatusBadRequest, rr.Code, "should be equal")
+		assert.Contains(t, rr.Header().Get("WWW-Authenticate"), `error="invalid_request"`)
+	})
+
+	t.Run("Only token errors are described", func(t *testing.T) {
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("GET", "/tokens", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+signTestJWT(t, "RS256", "", rsaKey, map[string]interface{}{"sub": "tom"}))
+		rr := httptest.NewRecorder()
+		v.Handler(ctxHandler).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code, "should be equal")
+		assert.Equal(t, `Bearer realm="api", error="invalid_token", error_description="invalid token signature"`, rr.Header().Get("WWW-Authenticate"), "should be equal")
+
+		rr = httptest.NewRecorder()
+		v.challenge(rr, errors.New("jwks: unexpected status 500 from http://idp.internal/jwks"))
+		assert.Equal(t, `Bearer realm="api", error="invalid_token", error_description="invalid token"`, rr.Header().Get("WWW-Authenticate"), "should be equal")
+	})
+}
+
+func ExampleJWTValidator() {
+	v, err := NewJWTValidator(JWTOptions{
+		Keys:      StaticKeys{"": []byte("secret")},
+		Issuer:    "https://issuer.example.com",
+		Audience:  "orders",
+		ClockSkew: 30 * time.Second,
+	})
+	if err != nil {
+		// error handling
+	}
+
+	defaultHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := JWTClaims(r.Context())
+		if err != nil {
+			// error handling
+		}
+		fmt.Printf("%s", claims.Subject)
+	})
+
+	http.Handle("/", v.Handler(defaultHandler))
+	http.ListenAndServe(":3000", nil)
+}
@@ -0,0 +1,319 @@
+package middlewares
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//LogEntry holds what is known about a request and its response when it's logged
+type LogEntry struct {
+	Request         *http.Request
+	Status          int
+	Size            int           // bytes written to the response body
+	Time            time.Time     // when the request was received
+	Duration        time.Duration // time taken to serve the request
+	TimeToFirstByte time.Duration // time taken until the response header was written
+	User            string        // subject of the authenticated Principal, empty if the request wasn't authenticated
+	RequestID       string        // ID of the request set by RequestID or found in the X-Request-ID header, if any
+	TraceID         string        // ID of the trace of the span started by Tracing, if any
+	SpanID          string        // ID of the span started by Tracing, if any
+	ResponseHeader  http.Header
+	ServerTiming    []ServerTiming // timings added by handlers with AddServerTiming
+}
+
+//LogFormatter writes the log line for a request to w, including the trailing newline
+type LogFormatter interface {
+	Format(w io.Writer, e *LogEntry) error
+}
+
+//LogFormatterFunc is an adapter to allow the use of ordinary functions as a LogFormatter
+type LogFormatterFunc func(w io.Writer, e *LogEntry) error
+
+//Format calls f(w, e)
+func (f LogFormatterFunc) Format(w io.Writer, e *LogEntry) error {
+	return f(w, e)
+}
+
+// Apache LogFormat strings of the predefined formats
+const (
+	commonLogFormat   = `%h %l %u %t "%r" %>s %b`
+	combinedLogFormat = commonLogFormat + ` "%{Referer}i" "%{User-agent}i"`
+)
+
+var (
+	//CommonLogFormat writes requests in the Common Log Format used by Apache and NCSA httpd
+	CommonLogFormat = mustApacheLogFormat(commonLogFormat)
+
+	//CombinedLogFormat writes requests in the Apache Combined Log Format, i.e. the Common Log Format followed by the referer and user agent
+	CombinedLogFormat = mustApacheLogFormat(combinedLogFormat)
+
+	//JSONLogFormat writes requests as JSON objects, one per line
+	JSONLogFormat LogFormatter = LogFormatterFunc(formatJSON)
+)
+
+// logDirective appends the value of one part of a LogFormat string to b
+type logDirective func(b *bytes.Buffer, e *LogEntry)
+
+type apacheLogFormat []logDirective
+
+//ApacheLogFormat returns a LogFormatter for format, which uses the directives of Apache's mod_log_config, e.g. `%h %l %u %t "%r" %>s %b %D`. An error is returned for unsupported directives.
+//
+// Supported are %%, %a, %b, %B, %D, %h, %H, %l, %m, %q, %r, %s, %t, %T, %{ms|us|s}T, %u, %U, %v, %{name}i, %{name}o and the time to first byte %^FB in microseconds. %{name}n is the duration in milliseconds of the ServerTiming name. The < and > modifiers are accepted but ignored, since requests aren't redirected internally.
+func ApacheLogFormat(format string) (LogFormatter, error) {
+	var f apacheLogFormat
+	var lit []byte
+	flush := func() {
+		if len(lit) > 0 {
+			s := string(lit)
+			f = append(f, func(b *bytes.Buffer, e *LogEntry) { b.WriteString(s) })
+			lit = nil
+		}
+	}
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			lit = append(lit, format[i])
+			continue
+		}
+		i++
+		var arg string
+		if i < len(format) && format[i] == '{' {
+			end := strings.IndexByte(format[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated %%{ in log format %q", format)
+			}
+			arg, i = format[i+1:i+end], i+end+1
+		}
+		for i < len(format) && (format[i] == '>' || format[i] == '<') {
+			i++
+		}
+		if i >= len(format) {
+			return nil, fmt.Errorf("incomplete directive at the end of log format %q", format)
+		}
+		if format[i] == '%' {
+			lit = append(lit, '%')
+			continue
+		}
+		if format[i] == '^' {
+			if i+2 >= len(format) || format[i+1:i+3] != "FB" {
+				return nil, fmt.Errorf("unsupported log directive in log format %q", format)
+			}
+			i += 2
+			flush()
+			f = append(f, func(b *bytes.Buffer, e *LogEntry) {
+				b.WriteString(strconv.FormatInt(e.TimeToFirstByte.Microseconds(), 10))
+			})
+			continue
+		}
+		d, err := apacheDirective(format[i], arg)
+		if err != nil {
+			return nil, err
+		}
+		flush()
+		f = append(f, d)
+	}
+	flush()
+	return f, nil
+}
+
+func mustApacheLogFormat(format string) LogFormatter {
+	f, err := ApacheLogFormat(format)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+//Format writes the log line of e
+func (f apacheLogFormat) Format(w io.Writer, e *LogEntry) error {
+	var b bytes.Buffer
+	for _, d := range f {
+		d(&b, e)
+	}
+	b.WriteByte('\n')
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+func apacheDirective(c byte, arg string) (logDirective, error) {
+	switch c {
+	case 'a', 'h':
+		return func(b *bytes.Buffer, e *LogEntry) { b.WriteString(remoteHost(e.Request)) }, nil
+	case 'b':
+		return func(b *bytes.Buffer, e *LogEntry) {
+			if e.Size == 0 {
+				b.WriteByte('-')
+				return
+			}
+			b.WriteString(strconv.Itoa(e.Size))
+		}, nil
+	case 'B':
+		return func(b *bytes.Buffer, e *LogEntry) { b.WriteString(strconv.Itoa(e.Size)) }, nil
+	case 'D':
+		return func(b *bytes.Buffer, e *LogEntry) { b.WriteString(strconv.FormatInt(e.Duration.Microseconds(), 10)) }, nil
+	case 'T':
+		unit := map[string]time.Duration{"": time.Second, "s": time.Second, "ms": time.Millisecond, "us": time.Microsecond}[arg]
+		if unit == 0 {
+			return nil, fmt.Errorf("unsupported log directive %%{%s}T", arg)
+		}
+		return func(b *bytes.Buffer, e *LogEntry) {
+			b.WriteString(strconv.FormatInt(int64(e.Duration/unit), 10))
+		}, nil
+	case 'n':
+		if arg == "" {
+			return nil, fmt.Errorf("log directive %%n requires a server timing name")
+		}
+		return func(b *bytes.Buffer, e *LogEntry) {
+			for _, st := range e.ServerTiming {
+				if st.Name == arg {
+					b.WriteString(strconv.FormatFloat(durationMillis(st.Duration), 'f', -1, 64))
+					return
+				}
+			}
+			b.WriteByte('-')
+		}, nil
+	case 'H':
+		return func(b *bytes.Buffer, e *LogEntry) { b.WriteString(e.Request.Proto) }, nil
+	case 'l':
+		return func(b *bytes.Buffer, e *LogEntry) { b.WriteByte('-') }, nil
+	case 'm':
+		return func(b *bytes.Buffer, e *LogEntry) { b.WriteString(e.Request.Method) }, nil
+	case 'q':
+		return func(b *bytes.Buffer, e *LogEntry) {
+			if q := e.Request.URL.RawQuery; q != "" {
+				writeLogEscaped(b, "?"+q)
+			}
+		}, nil
+	case 'r':
+		return func(b *bytes.Buffer, e *LogEntry) {
+			writeLogEscaped(b, e.Request.Method+" "+requestURI(e.Request)+" "+e.Request.Proto)
+		}, nil
+	case 's':
+		return func(b *bytes.Buffer, e *LogEntry) { b.WriteString(strconv.Itoa(e.Status)) }, nil
+	case 't':
+		if arg != "" {
+			return nil, fmt.Errorf("unsupported log directive %%{%s}t", arg)
+		}
+		return func(b *bytes.Buffer, e *LogEntry) { b.WriteString("[" + e.Time.Format(timeFormat) + "]") }, nil
+	case 'u':
+		return func(b *bytes.Buffer, e *LogEntry) { writeLogField(b, e.User) }, nil
+	case 'U':
+		return func(b *bytes.Buffer, e *LogEntry) { writeLogEscaped(b, e.Request.URL.EscapedPath()) }, nil
+	case 'v':
+		return func(b *bytes.Buffer, e *LogEntry) { writeLogField(b, e.Request.Host) }, nil
+	case 'i':
+		if arg == "" {
+			return nil, fmt.Errorf("log directive %%i requires a header name")
+		}
+		return func(b *bytes.Buffer, e *LogEntry) { writeLogField(b, e.Request.Header.Get(arg)) }, nil
+	case 'o':
+		if arg == "" {
+			return nil, fmt.Errorf("log directive %%o requires a header name")
+		}
+		return func(b *bytes.Buffer, e *LogEntry) { writeLogField(b, e.ResponseHeader.Get(arg)) }, nil
+	}
+	return nil, fmt.Errorf("unsupported log directive %%%c", c)
+}
+
+// remoteHost returns the address of the client as resolved by ClientIP, or "-" if it's unknown
+func remoteHost(r *http.Request) string {
+	if ip := ClientIP(r); ip != "" {
+		return ip
+	}
+	return "-"
+}
+
+// requestURI returns the URI of the request line, which is only set on server requests
+func requestURI(r *http.Request) string {
+	if r.RequestURI != "" {
+		return r.RequestURI
+	}
+	return r.URL.RequestURI()
+}
+
+// writeLogField writes s escaped, or "-" if s is empty
+func writeLogField(b *bytes.Buffer, s string) {
+	if s == "" {
+		b.WriteByte('-')
+		return
+	}
+	writeLogEscaped(b, s)
+}
+
+// writeLogEscaped writes s like Apache does, with quotes, backslashes and non-printable characters escaped
+func writeLogEscaped(b *bytes.Buffer, s string) {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+type jsonLogLine struct {
+	Time       string  `json:"time"`
+	RemoteAddr string  `json:"remote_addr"`
+	User       string  `json:"user,omitempty"`
+	RequestID  string  `json:"request_id,omitempty"`
+	TraceID    string  `json:"trace_id,omitempty"`
+	SpanID     string  `json:"span_id,omitempty"`
+	Method     string  `json:"method"`
+	URI        string  `json:"uri"`
+	Proto      string  `json:"proto"`
+	Host       string  `json:"host,omitempty"`
+	Status     int     `json:"status"`
+	Size       int     `json:"size"`
+	Duration   float64 `json:"duration_ms"`
+	TTFB       float64 `json:"ttfb_ms"`
+	Referer    string  `json:"referer,omitempty"`
+	UserAgent  string  `json:"user_agent,omitempty"`
+
+	ServerTiming map[string]float64 `json:"server_timing,omitempty"`
+}
+
+func formatJSON(w io.Writer, e *LogEntry) error {
+	line := jsonLogLine{
+		Time:       e.Time.Format(time.RFC3339Nano),
+		RemoteAddr: remoteHost(e.Request),
+		User:       e.User,
+		RequestID:  e.RequestID,
+		TraceID:    e.TraceID,
+		SpanID:     e.SpanID,
+		Method:     e.Request.Method,
+		URI:        requestURI(e.Request),
+		Proto:      e.Request.Proto,
+		Host:       e.Request.Host,
+		Status:     e.Status,
+		Size:       e.Size,
+		Duration:   durationMillis(e.Duration),
+		TTFB:       durationMillis(e.TimeToFirstByte),
+		Referer:    e.Request.Referer(),
+		UserAgent:  e.Request.UserAgent(),
+	}
+	if len(e.ServerTiming) > 0 {
+		line.ServerTiming = map[string]float64{}
+		for _, st := range e.ServerTiming {
+			line.ServerTiming[st.Name] = durationMillis(st.Duration)
+		}
+	}
+	b, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+func durationMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -2,42 +2,129 @@ package middlewares
 
 import (
 	"fmt"
+	"io"
 	"net/http"
 	"time"
 )
 
-type loggingHandler struct {
-	http.ResponseWriter
-	statusCode int
-	contentLen int
-}
-
 const (
 	timeFormat = "02/Jan/2006:15:04:05 -0700"
 )
 
-//LoggingHandler returns a http.Handler that wraps next, and prints requests and responses in Apache Combined Log Format.
+//LoggingHandler returns a http.Handler that wraps next, and prints requests and responses in Apache Combined Log Format. The user is the subject of the Principal stored by any authentication handler further down the chain. Use a Logger for other formats.
 func LoggingHandler(next http.Handler) http.Handler {
+	return defaultLogger.Handler(next)
+}
+
+//LoggerOptions holds the settings used to build a Logger
+type LoggerOptions struct {
+	Formatter LogFormatter // formats the log lines, defaults to CombinedLogFormat
+	Output    io.Writer    // where the log lines are written, defaults to the output set with SetOutput
+}
+
+//Logger logs requests and responses using a LogFormatter
+type Logger struct {
+	formatter LogFormatter
+	out       io.Writer
+	now       func() time.Time
+}
+
+var defaultLogger = &Logger{formatter: CombinedLogFormat, now: time.Now}
+
+//NewLogger returns a Logger using the settings in opts
+func NewLogger(opts LoggerOptions) (*Logger, error) {
+	l := &Logger{formatter: opts.Formatter, out: opts.Output, now: time.Now}
+	if l.formatter == nil {
+		l.formatter = defaultLogger.formatter
+	}
+	return l, nil
+}
+
+//Handler returns a http.Handler that wraps next, and logs each request after next has served it
+func (l *Logger) Handler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		lw := &loggingHandler{w, http.StatusOK, 0}
-		next.ServeHTTP(lw, r)
-		printLog(lw.statusCode, lw.contentLen, r)
+		l.log(serveLogged(next, w, r, l.now))
 	})
 }
 
-func printLog(status, length int, r *http.Request) {
-	fmt.Fprintf(output, "%s %s %s %s %s %s %s %d %d %s %s\n", r.RemoteAddr, "-", "-", time.Now().Format(timeFormat), r.Method, r.RequestURI, r.Proto, status, length, r.Referer(), r.UserAgent())
+// serveLogged serves r using next and returns the LogEntry describing the request and its response
+func serveLogged(next http.Handler, w http.ResponseWriter, r *http.Request, now func() time.Time) *LogEntry {
+	start := now()
+	var firstByte time.Time
+	rec := newResponseRecorder(w)
+	rec.onHeader = func() { firstByte = now() }
+	ctx, _ := withRequestInfo(r.Context())
+	ctx, timings := withServerTimings(ctx)
+	r = r.WithContext(ctx)
+	next.ServeHTTP(rec.wrap(), r)
+	e := &LogEntry{
+		Request:        r,
+		Status:         rec.Status(),
+		Size:           int(rec.BytesWritten()),
+		Time:           start,
+		Duration:       now().Sub(start),
+		User:           logUser(r),
+		RequestID:      logRequestID(r, w.Header()),
+		ResponseHeader: w.Header(),
+		ServerTiming:   timings.list(),
+	}
+	if sc := logSpanContext(r); sc.IsValid() {
+		e.TraceID, e.SpanID = sc.TraceID.String(), sc.SpanID.String()
+	}
+	e.TimeToFirstByte = e.Duration // the header is written by net/http after next returns
+	if !firstByte.IsZero() {
+		e.TimeToFirstByte = firstByte.Sub(start)
+	}
+	return e
+}
+
+func (l *Logger) log(e *LogEntry) {
+	out := l.out
+	if out == nil {
+		out = output
+	}
+	if err := l.formatter.Format(out, e); err != nil {
+		fmt.Fprintf(output, "warn: %s\n", err.Error())
+	}
+}
+
+// logRequestID returns the request ID set by RequestID, or else the one sent by the client or set on the response by a handler further down the chain. IDs that RequestID wouldn't accept aren't logged.
+func logRequestID(r *http.Request, h http.Header) string {
+	if id, err := RequestIDFrom(r.Context()); err == nil {
+		return id
+	}
+	if info := requestInfoFrom(r.Context()); info != nil && info.requestID != "" {
+		return info.requestID
+	}
+	for _, id := range []string{r.Header.Get(defaultRequestIDHeader), h.Get(defaultRequestIDHeader)} {
+		if validRequestID(id, defaultRequestIDMaxLength) {
+			return id
+		}
+	}
+	return ""
 }
 
-//WriteHeader shadows http.ResponseWriter.WriteHeader()
-func (l *loggingHandler) WriteHeader(code int) {
-	l.statusCode = code
-	l.ResponseWriter.WriteHeader(code)
+// logSpanContext returns the span context of the span started by Tracing, or the zero SpanContext if the request isn't traced
+func logSpanContext(r *http.Request) SpanContext {
+	if s, err := SpanFrom(r.Context()); err == nil {
+		return s.SpanContext()
+	}
+	if info := requestInfoFrom(r.Context()); info != nil {
+		return info.spanContext
+	}
+	return SpanContext{}
 }
 
-//Write shadows http.ResponseWriter.Write
-func (l *loggingHandler) Write(b []byte) (n int, err error) {
-	n, err = l.ResponseWriter.Write(b)
-	l.contentLen += n
-	return
+// logUser returns the subject of the request's principal, or an empty string if the request hasn't been authenticated
+func logUser(r *http.Request) string {
+	p, err := PrincipalFrom(r.Context())
+	if err != nil {
+		if info := requestInfoFrom(r.Context()); info != nil {
+			p = info.principal
+		}
+	}
+	if p == nil {
+		return ""
+	}
+	return p.Subject()
 }
@@ -2,38 +2,92 @@ package middlewares
 
 import (
 	"bytes"
+	"encoding/json"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestLogFormat(t *testing.T) {
-	req, err := http.NewRequest("GET", "/index.html", nil)
-	req.Header.Set("User-Agent", "MWTests")
+	req, err := http.NewRequest("GET", "/index.html?q=1", nil)
+	req.Header.Set("User-Agent", `MW "Tests"`)
 	req.Header.Set("Referer", "testing")
+	req.RemoteAddr = "192.0.2.1:51234"
 	if err != nil {
 		log.Fatal(err)
 	}
-	status := http.StatusOK
-	conLen := 23
-	var b bytes.Buffer
-	SetOutput(&b)
-	printLog(status, conLen, req)
-	rval := string(b.Bytes())
-	assert.Contains(t, rval, "GET  HTTP/1.1 200 23 testing MWTests")
+	e := &LogEntry{
+		Request:        req,
+		Status:         http.StatusOK,
+		Size:           23,
+		Time:           time.Date(2000, 10, 10, 13, 55, 36, 0, time.FixedZone("", -7*3600)),
+		Duration:       1500 * time.Microsecond,
+		User:           "frank",
+		ResponseHeader: http.Header{"Content-Type": {"text/html"}},
+	}
+	format := func(f LogFormatter) string {
+		var b bytes.Buffer
+		if err := f.Format(&b, e); err != nil {
+			t.Fatal(err)
+		}
+		return b.String()
+	}
+
+	t.Run("Common", func(t *testing.T) {
+		assert.Equal(t, "192.0.2.1 - frank [10/Oct/2000:13:55:36 -0700] \"GET /index.html?q=1 HTTP/1.1\" 200 23\n", format(CommonLogFormat), "should be equal")
+	})
+
+	t.Run("Combined", func(t *testing.T) {
+		assert.Equal(t, "192.0.2.1 - frank [10/Oct/2000:13:55:36 -0700] \"GET /index.html?q=1 HTTP/1.1\" 200 23 \"testing\" \"MW \\\"Tests\\\"\"\n", format(CombinedLogFormat), "should be equal")
+	})
+
+	t.Run("Empty fields", func(t *testing.T) {
+		e := *e
+		e.User, e.Size = "", 0
+		r := req.Clone(req.Context())
+		r.Header.Del("Referer")
+		e.Request = r
+		var b bytes.Buffer
+		CombinedLogFormat.Format(&b, &e)
+		assert.Contains(t, b.String(), "192.0.2.1 - - [", "should contain empty user")
+		assert.Contains(t, b.String(), " 200 - \"-\" ", "should contain empty size and referer")
+	})
+
+	t.Run("Directives", func(t *testing.T) {
+		f, err := ApacheLogFormat(`%a %m %U%q %H %>s %B %D %T %{Content-Type}o %v 100%%`)
+		assert.Nil(t, err, "should be nil")
+		assert.Equal(t, "192.0.2.1 GET /index.html?q=1 HTTP/1.1 200 23 1500 0 text/html - 100%\n", format(f), "should be equal")
+	})
+
+	t.Run("Unsupported directives", func(t *testing.T) {
+		for _, f := range []string{"%Z", "%{Referer", "%h %", "%i", "%{%d/%b}t"} {
+			_, err := ApacheLogFormat(f)
+			assert.NotNil(t, err, "should not be nil: %s", f)
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		var line map[string]interface{}
+		assert.Nil(t, json.Unmarshal([]byte(format(JSONLogFormat)), &line), "should be nil")
+		assert.Equal(t, "frank", line["user"], "should be equal")
+		assert.Equal(t, "/index.html?q=1", line["uri"], "should be equal")
+		assert.Equal(t, 200.0, line["status"], "should be equal")
+		assert.Equal(t, 1.5, line["duration_ms"], "should be equal")
+	})
 }
 
 func TestShadowResponse(t *testing.T) {
 	rr := httptest.NewRecorder()
-	l := loggingHandler{rr, http.StatusOK, 0}
+	l := WrapResponseWriter(rr)
 	l.WriteHeader(http.StatusBadGateway)
 	sval := "this is a body"
 	l.Write([]byte(sval))
-	assert.Equal(t, http.StatusBadGateway, l.statusCode, "should be equal")
-	assert.Equal(t, len(sval), l.contentLen, "should be equal")
+	assert.Equal(t, http.StatusBadGateway, l.Status(), "should be equal")
+	assert.Equal(t, int64(len(sval)), l.BytesWritten(), "should be equal")
 }
 
 func TestLoggingHandler(t *testing.T) {
@@ -57,7 +111,7 @@ func TestLoggingHandler(t *testing.T) {
 		handler := LoggingHandler(emptyHandler)
 		handler.ServeHTTP(rr, req)
 		rval := string(b.Bytes())
-		assert.Contains(t, rval, "GET  HTTP/1.1 200 14")
+		assert.Contains(t, rval, `"GET /index HTTP/1.1" 200 14`)
 	})
 
 	t.Run("404 Not Found", func(t *testing.T) {
@@ -67,9 +121,85 @@ func TestLoggingHandler(t *testing.T) {
 		handler := LoggingHandler(notfoundHandler)
 		handler.ServeHTTP(rr, req)
 		rval := string(b.Bytes())
-		assert.Contains(t, rval, "GET  HTTP/1.1 404 0")
+		assert.Contains(t, rval, `"GET /index HTTP/1.1" 404 -`)
+	})
+
+}
+
+func TestLogger(t *testing.T) {
+	var b bytes.Buffer
+	l, err := NewLogger(LoggerOptions{Formatter: JSONLogFormat, Output: &b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("GET", "/index", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetOutput(&bytes.Buffer{})
+	l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})).ServeHTTP(httptest.NewRecorder(), req)
+	assert.Contains(t, b.String(), `"status":418`, "should contain status")
+}
+
+func TestLoggerTimings(t *testing.T) {
+	start := time.Date(2000, 10, 10, 13, 55, 36, 0, time.UTC)
+	ticks := []time.Duration{0, 10 * time.Millisecond, 30 * time.Millisecond}
+	f, err := ApacheLogFormat(`%t %D %{ms}T %^FB %{db}n %{cache}n`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	l, err := NewLogger(LoggerOptions{Formatter: f, Output: &b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.now = func() time.Time {
+		d := ticks[0]
+		ticks = ticks[1:]
+		return start.Add(d)
+	}
+	req, err := http.NewRequest("GET", "/slow", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		AddServerTiming(r.Context(), "db", 2500*time.Microsecond, "orders query")
+		w.Write([]byte("slow"))
+	})).ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "[10/Oct/2000:13:55:36 +0000] 30000 30 10000 2.5 -\n", b.String(), "should be equal")
+}
+
+func TestServerTimingJSON(t *testing.T) {
+	req, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	JSONLogFormat.Format(&b, &LogEntry{Request: req, TimeToFirstByte: 4 * time.Millisecond, ServerTiming: []ServerTiming{{Name: "db", Duration: time.Millisecond}}})
+	assert.Contains(t, b.String(), `"ttfb_ms":4`, "should contain ttfb")
+	assert.Contains(t, b.String(), `"server_timing":{"db":1}`, "should contain server timing")
+
+	// no logging handler in the chain
+	AddServerTiming(req.Context(), "db", time.Millisecond, "")
+}
+
+func ExampleLogger() {
+	f, err := ApacheLogFormat(`%h %l %u %t "%r" %>s %b %D`)
+	if err != nil {
+		// error handling
+	}
+	l, err := NewLogger(LoggerOptions{Formatter: f})
+	if err != nil {
+		// error handling
+	}
+	defaultHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// do something
 	})
 
+	http.Handle("/", l.Handler(defaultHandler))
+	http.ListenAndServe(":3000", nil)
 }
 
 func ExampleLoggingHandler() {
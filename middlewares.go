@@ -2,6 +2,7 @@
 package middlewares
 
 import (
+	"context"
 	"io"
 	"os"
 )
@@ -14,6 +15,19 @@ const (
 	tokenContextKey contextKey = "mw_token_context_key"
 	fpContextKey    contextKey = "mw_fp_context_key"
 	authContextKey  contextKey = "mw_auth_context_key"
+
+	principalContextKey   contextKey = "mw_principal_context_key"
+	requestInfoContextKey contextKey = "mw_request_info_context_key"
+	claimsContextKey      contextKey = "mw_claims_context_key"
+
+	introspectionContextKey contextKey = "mw_introspection_context_key"
+	apiKeyContextKey        contextKey = "mw_apikey_context_key"
+	authSchemeContextKey    contextKey = "mw_auth_scheme_context_key"
+	serverTimingContextKey  contextKey = "mw_server_timing_context_key"
+	clientIPContextKey      contextKey = "mw_client_ip_context_key"
+	requestIDContextKey     contextKey = "mw_request_id_context_key"
+	spanContextKey          contextKey = "mw_span_context_key"
+	remoteSpanContextKey    contextKey = "mw_remote_span_context_key"
 )
 
 func init() {
@@ -26,3 +40,24 @@ func init() {
 func SetOutput(w io.Writer) {
 	output = w
 }
+
+// requestInfo is placed in the context by outer handlers, such as LoggingHandler, that need to see information stored by handlers further down the chain
+type requestInfo struct {
+	principal Principal
+	clientIP  string
+	requestID string
+
+	spanContext SpanContext
+}
+
+// withRequestInfo returns a context whose requestInfo receives the information stored further down the chain
+func withRequestInfo(ctx context.Context) (context.Context, *requestInfo) {
+	info := &requestInfo{}
+	return context.WithValue(ctx, requestInfoContextKey, info), info
+}
+
+// requestInfoFrom returns the requestInfo of ctx, or nil if there is none
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoContextKey).(*requestInfo)
+	return info
+}
@@ -0,0 +1,114 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+)
+
+// Authentication methods reported by Principal.AuthMethod for the handlers in this package
+const (
+	AuthMethodBasic         = "basic"
+	AuthMethodJWT           = "jwt"
+	AuthMethodIntrospection = "introspection"
+	AuthMethodAPIKey        = "apikey"
+	AuthMethodSignature     = "signature"
+
+	AuthMethodBasicUnverified = "basic_unverified" // Basic credentials extracted by BasicAuthorizationHandler or a BasicExtractor, the password hasn't been checked
+	AuthMethodTokenUnverified = "token_unverified" // a token extracted by TokenHandler or a TokenReader, which hasn't been validated
+)
+
+//Principal is the identity of a caller. Every handler in this package that authenticates a request or extracts its credentials stores it in the request context, where it can be read using PrincipalFrom regardless of which handler ran.
+//
+// BasicAuthorizationHandler, BasicExtractor, TokenHandler and TokenReader don't verify the credentials, their principals have the method AuthMethodBasicUnverified or AuthMethodTokenUnverified. Use IsVerified to tell them apart. A handler verifying the credentials further down the chain replaces the principal.
+type Principal interface {
+	Subject() string                // unique identifier of the caller, e.g. a user name
+	AuthMethod() string             // how the caller was authenticated, e.g. AuthMethodBasic
+	Attributes() map[string]string  // additional information from the authentication source, e.g. the client_id of an introspected token
+	Claims() map[string]interface{} // claims of the token the caller was authenticated with, nil if there's no token
+}
+
+//ScopedPrincipal is implemented by principals holding OAuth2 scopes, it's used by RequireScopes and RequireAnyScope
+type ScopedPrincipal interface {
+	Principal
+	HasScope(scope string) bool
+}
+
+//RolePrincipal is implemented by principals holding roles, it's used by RequireRoles and RequireAnyRole
+type RolePrincipal interface {
+	Principal
+	HasRole(role string) bool
+}
+
+//Identity is the Principal used by the handlers in this package, it can also be returned by an Authenticator
+type Identity struct {
+	Name      string
+	Method    string // set by the authentication handler if empty
+	Scopes    []string
+	Roles     []string
+	Attrs     map[string]string
+	RawClaims map[string]interface{}
+}
+
+//Subject returns the name of the identity
+func (i *Identity) Subject() string {
+	return i.Name
+}
+
+//AuthMethod returns the method used to authenticate the identity
+func (i *Identity) AuthMethod() string {
+	return i.Method
+}
+
+//Attributes returns the attributes of the identity
+func (i *Identity) Attributes() map[string]string {
+	return i.Attrs
+}
+
+//Claims returns the token claims of the identity
+func (i *Identity) Claims() map[string]interface{} {
+	return i.RawClaims
+}
+
+//HasScope reports whether scope has been granted to the identity
+func (i *Identity) HasScope(scope string) bool {
+	return containsString(i.Scopes, scope)
+}
+
+//HasRole reports whether the identity has role
+func (i *Identity) HasRole(role string) bool {
+	return containsString(i.Roles, role)
+}
+
+//PrincipalFrom returns the Principal stored in the context by an authentication handler, or an error if the request hasn't been authenticated
+func PrincipalFrom(ctx context.Context) (Principal, error) {
+	if p, ok := ctx.Value(principalContextKey).(Principal); ok && p != nil {
+		return p, nil
+	}
+	return nil, errors.New("no principal found in context")
+}
+
+//IsVerified reports whether the credentials of p have been verified, i.e. whether p wasn't stored by a handler that only extracts credentials
+func IsVerified(p Principal) bool {
+	switch p.AuthMethod() {
+	case AuthMethodBasicUnverified, AuthMethodTokenUnverified:
+		return false
+	}
+	return true
+}
+
+func withPrincipal(ctx context.Context, p Principal) context.Context {
+	if info := requestInfoFrom(ctx); info != nil {
+		info.principal = p
+	}
+	return context.WithValue(ctx, principalContextKey, p)
+}
+
+// withMethod returns p with its authentication method set to method, an *Identity with a method is returned unchanged
+func withMethod(p Principal, method string) Principal {
+	if i, ok := p.(*Identity); ok && i.Method == "" {
+		c := *i
+		c.Method = method
+		return &c
+	}
+	return p
+}
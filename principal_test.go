@@ -0,0 +1,107 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrincipalFrom(t *testing.T) {
+	t.Run("Empty context", func(t *testing.T) {
+		p, err := PrincipalFrom(context.Background())
+		assert.NotNil(t, err, "should not be nil")
+		assert.Nil(t, p, "should be nil")
+	})
+
+	t.Run("Request info receives principal", func(t *testing.T) {
+		ctx, info := withRequestInfo(context.Background())
+		withPrincipal(ctx, &Identity{Name: "tom"})
+		assert.Equal(t, "tom", info.principal.Subject(), "should be equal")
+	})
+
+	t.Run("Method is set on identities", func(t *testing.T) {
+		i := &Identity{Name: "tom"}
+		p := withMethod(i, AuthMethodBasic)
+		assert.Equal(t, AuthMethodBasic, p.AuthMethod(), "should be equal")
+		assert.Empty(t, i.Method, "should be empty")
+
+		p = withMethod(&Identity{Name: "tom", Method: "ldap"}, AuthMethodBasic)
+		assert.Equal(t, "ldap", p.AuthMethod(), "should be equal")
+	})
+}
+
+func TestUniformPrincipal(t *testing.T) {
+	secret := []byte("thisisasecret")
+	jv, err := NewJWTValidator(JWTOptions{Keys: StaticKeys{"": secret}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bv, err := NewBasicValidator(BasicAuthOptions{Authenticator: AuthenticatorFunc(func(user, pass string, r *http.Request) (Principal, error) {
+		return &Identity{Name: user}, nil
+	})})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := signTestJWT(t, "HS256", "", secret, map[string]interface{}{"sub": "tom"})
+	for name, tc := range map[string]struct {
+		handler  func(http.Handler) http.Handler
+		auth     string
+		method   string
+		subject  string
+		verified bool
+	}{
+		"Basic":                     {bv.Handler, "Basic dG9tOnNoYXJkd2FyZQ==", AuthMethodBasic, "tom", true},
+		"JWT":                       {jv.Handler, "Bearer " + token, AuthMethodJWT, "tom", true},
+		"BasicAuthorizationHandler": {BasicAuthorizationHandler, "Basic dG9tOnNoYXJkd2FyZQ==", AuthMethodBasicUnverified, "tom", false},
+		"TokenHandler":              {TokenHandler, "Bearer " + token, AuthMethodTokenUnverified, "", false},
+		"TokenHandler and JWT": {func(next http.Handler) http.Handler {
+			return TokenHandler(jv.Handler(next))
+		}, "Bearer " + token, AuthMethodJWT, "tom", true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "/index", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", tc.auth)
+
+			var b bytes.Buffer
+			SetOutput(&b)
+			rr := httptest.NewRecorder()
+			LoggingHandler(tc.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				p, err := PrincipalFrom(r.Context())
+				assert.Nil(t, err, "should be nil")
+				assert.Equal(t, tc.subject, p.Subject(), "should be equal")
+				assert.Equal(t, tc.method, p.AuthMethod(), "should be equal")
+				assert.Equal(t, tc.verified, IsVerified(p), "should be equal")
+			}))).ServeHTTP(rr, req)
+			if tc.subject != "" {
+				assert.Contains(t, b.String(), " - tom ", "should contain user")
+			}
+		})
+	}
+}
+
+func ExamplePrincipalFrom() {
+	defaultHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := PrincipalFrom(r.Context())
+		if err != nil {
+			// not authenticated
+		}
+		switch p.AuthMethod() {
+		case AuthMethodJWT:
+			_ = p.Claims()["email"]
+		case AuthMethodIntrospection:
+			_ = p.Attributes()["client_id"]
+		}
+	})
+
+	v, _ := NewJWTValidator(JWTOptions{Keys: StaticKeys{"": []byte("secret")}})
+	http.Handle("/", LoggingHandler(v.Handler(defaultHandler)))
+	http.ListenAndServe(":3000", nil)
+}
@@ -0,0 +1,185 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Headers consulted by RealIP, in the default order
+const (
+	headerForwarded     = "Forwarded"
+	headerXForwardedFor = "X-Forwarded-For"
+	headerXRealIP       = "X-Real-Ip" // canonical form of X-Real-IP
+)
+
+//RealIPOptions holds the settings used to build a RealIP
+type RealIPOptions struct {
+	TrustedProxies []string // IP addresses or CIDR ranges of the proxies whose headers are trusted, e.g. "10.0.0.0/8"
+	Headers        []string // headers consulted in order, defaults to Forwarded, X-Forwarded-For and X-Real-IP
+}
+
+//RealIP resolves the address of the client behind trusted proxies. The forwarding headers are only used if the immediate peer is a trusted proxy, otherwise the peer is the client.
+type RealIP struct {
+	trusted []netip.Prefix
+	headers []string
+}
+
+//NewRealIP returns a RealIP using the settings in opts, or an error if a trusted proxy or header is invalid
+func NewRealIP(opts RealIPOptions) (*RealIP, error) {
+	ri := &RealIP{headers: append([]string(nil), opts.Headers...)}
+	for _, s := range opts.TrustedProxies {
+		p, err := parsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		ri.trusted = append(ri.trusted, p)
+	}
+	if len(ri.headers) == 0 {
+		ri.headers = []string{headerForwarded, headerXForwardedFor, headerXRealIP}
+	}
+	for i, h := range ri.headers {
+		ri.headers[i] = http.CanonicalHeaderKey(h)
+		switch ri.headers[i] {
+		case headerForwarded, headerXForwardedFor, headerXRealIP:
+		default:
+			return nil, fmt.Errorf("unsupported client ip header %q", h)
+		}
+	}
+	return ri, nil
+}
+
+// parsePrefix parses an IP address or CIDR range
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		return p.Masked(), err
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(a.Unmap(), a.Unmap().BitLen()), nil
+}
+
+//Handler returns a http.Handler that resolves the client address of the request before calling next. The address is available through ClientIP, and is used by LoggingHandler, Logger and StructuredLogger.
+func (ri *RealIP) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := ri.Resolve(r)
+		if info := requestInfoFrom(r.Context()); info != nil {
+			info.clientIP = ip
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPContextKey, ip)))
+	})
+}
+
+//Resolve returns the address of the client of r
+func (ri *RealIP) Resolve(r *http.Request) string {
+	peer, err := parseHostAddr(r.RemoteAddr)
+	if err != nil || !ri.isTrusted(peer) {
+		return remoteAddrHost(r.RemoteAddr)
+	}
+	// only the first header present is used, a malformed header isn't replaced by a less preferred one
+	for _, h := range ri.headers {
+		values := r.Header.Values(h)
+		if len(values) == 0 {
+			continue
+		}
+		if ip, ok := ri.client(forwardingHops(h, values)); ok {
+			return ip.String()
+		}
+		return peer.String()
+	}
+	return peer.String()
+}
+
+// forwardingHops returns the addresses listed in the values of the forwarding header h, from client to the closest proxy
+func forwardingHops(h string, values []string) []string {
+	switch h {
+	case headerForwarded:
+		return forwardedFor(values)
+	case headerXForwardedFor:
+		var hops []string
+		for _, v := range values {
+			hops = append(hops, strings.Split(v, ",")...)
+		}
+		return hops
+	}
+	return values[len(values)-1:] // X-Real-IP holds a single address
+}
+
+// client returns the rightmost address of hops that isn't a trusted proxy, or the leftmost address if they all are. It fails if an address before the client is malformed.
+func (ri *RealIP) client(hops []string) (netip.Addr, bool) {
+	var last netip.Addr
+	for i := len(hops) - 1; i >= 0; i-- {
+		a, err := parseHostAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		if !ri.isTrusted(a) {
+			return a, true
+		}
+		last = a
+	}
+	return last, last.IsValid()
+}
+
+func (ri *RealIP) isTrusted(a netip.Addr) bool {
+	for _, p := range ri.trusted {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the for parameters of the elements of RFC 7239 Forwarded headers, in order
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, v := range values {
+		for _, elem := range strings.Split(v, ",") {
+			for _, pair := range strings.Split(elem, ";") {
+				k, val, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				if strings.EqualFold(k, "for") {
+					hops = append(hops, strings.Trim(val, `"`))
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// parseHostAddr parses an IP address that may have a port and may be enclosed in brackets, e.g. "[2001:db8::1]:4711"
+func parseHostAddr(s string) (netip.Addr, error) {
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr().Unmap(), nil
+	}
+	a, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]"))
+	if err != nil {
+		return netip.Addr{}, errors.New("malformed ip address")
+	}
+	return a.Unmap(), nil
+}
+
+// remoteAddrHost returns the host part of addr, which is usually an address and port
+func remoteAddrHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+//ClientIP returns the address of the client of r as resolved by RealIP, or the address of the immediate peer if RealIP hasn't run
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPContextKey).(string); ok {
+		return ip
+	}
+	if info := requestInfoFrom(r.Context()); info != nil && info.clientIP != "" {
+		return info.clientIP
+	}
+	return remoteAddrHost(r.RemoteAddr)
+}
@@ -0,0 +1,89 @@
+package middlewares
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRealIP(t *testing.T) {
+	ri, err := NewRealIP(RealIPOptions{TrustedProxies: []string{"10.0.0.0/8", "2001:db8::1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolve := func(remote string, headers map[string]string) string {
+		req, err := http.NewRequest("GET", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = remote
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		return ri.Resolve(req)
+	}
+
+	for name, tc := range map[string]struct {
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		"Untrusted peer":           {"192.0.2.1:1234", map[string]string{"X-Forwarded-For": "198.51.100.7"}, "192.0.2.1"},
+		"X-Forwarded-For":          {"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "198.51.100.7"}, "198.51.100.7"},
+		"Spoofed X-Forwarded-For":  {"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.9, 198.51.100.7, 10.0.0.2"}, "198.51.100.7"},
+		"Only trusted proxies":     {"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		"X-Real-IP":                {"10.0.0.1:1234", map[string]string{"X-Real-IP": "198.51.100.7"}, "198.51.100.7"},
+		"Forwarded":                {"[2001:db8::1]:443", map[string]string{"Forwarded": `for=192.0.2.60;proto=http, For="[2001:db8:cafe::17]:4711"`}, "2001:db8:cafe::17"},
+		"Forwarded precedes":       {"10.0.0.1:1234", map[string]string{"Forwarded": "for=198.51.100.7", "X-Forwarded-For": "203.0.113.9"}, "198.51.100.7"},
+		"Obfuscated identifier":    {"10.0.0.1:1234", map[string]string{"Forwarded": "for=_hidden"}, "10.0.0.1"},
+		"Malformed address":        {"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "not-an-ip"}, "10.0.0.1"},
+		"No headers":               {"10.0.0.1:1234", nil, "10.0.0.1"},
+		"IPv4-mapped trusted peer": {"[::ffff:10.0.0.1]:1234", map[string]string{"X-Real-IP": "198.51.100.7"}, "198.51.100.7"},
+	} {
+		assert.Equal(t, tc.want, resolve(tc.remote, tc.headers), "should be equal: %s", name)
+	}
+
+	t.Run("Invalid options", func(t *testing.T) {
+		_, err := NewRealIP(RealIPOptions{TrustedProxies: []string{"10.0.0.0/33"}})
+		assert.NotNil(t, err, "should not be nil")
+		_, err = NewRealIP(RealIPOptions{Headers: []string{"X-Client-IP"}})
+		assert.NotNil(t, err, "should not be nil")
+	})
+
+	t.Run("Used by the access log", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("X-Forwarded-For", "198.51.100.7")
+
+		for name, h := range map[string]http.Handler{
+			"RealIP outside": ri.Handler(LoggingHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))),
+			"RealIP inside": LoggingHandler(ri.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "198.51.100.7", ClientIP(r), "should be equal")
+			}))),
+		} {
+			var b bytes.Buffer
+			SetOutput(&b)
+			h.ServeHTTP(httptest.NewRecorder(), req)
+			assert.Contains(t, b.String(), "198.51.100.7 - - [", "should contain client ip: %s", name)
+		}
+	})
+}
+
+func ExampleRealIP() {
+	ri, err := NewRealIP(RealIPOptions{TrustedProxies: []string{"10.0.0.0/8"}})
+	if err != nil {
+		// error handling
+	}
+	defaultHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = ClientIP(r)
+	})
+
+	http.Handle("/", ri.Handler(LoggingHandler(defaultHandler)))
+	http.ListenAndServe(":3000", nil)
+}
@@ -0,0 +1,128 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultRequestIDHeader    = "X-Request-ID"
+	defaultRequestIDMaxLength = 64
+)
+
+//RequestIDOptions holds the settings used to build a RequestID
+type RequestIDOptions struct {
+	Header    string        // header holding the request ID, defaults to X-Request-ID
+	Generator func() string // generates IDs for requests without a valid one, defaults to NewUUIDv7
+	MaxLength int           // longest incoming ID that is reused, defaults to 64
+}
+
+//RequestID assigns every request an ID, so that its log lines can be correlated with those of other services. Incoming IDs are reused if they are at most MaxLength characters long and only contain letters, digits and the characters - _ . : otherwise a new ID is generated.
+type RequestID struct {
+	header    string
+	generate  func() string
+	maxLength int
+}
+
+//NewRequestID returns a RequestID using the settings in opts
+func NewRequestID(opts RequestIDOptions) (*RequestID, error) {
+	rid := &RequestID{
+		header:    http.CanonicalHeaderKey(opts.Header),
+		generate:  opts.Generator,
+		maxLength: opts.MaxLength,
+	}
+	if rid.header == "" {
+		rid.header = defaultRequestIDHeader
+	}
+	if rid.generate == nil {
+		rid.generate = NewUUIDv7
+	}
+	if rid.maxLength <= 0 {
+		rid.maxLength = defaultRequestIDMaxLength
+	}
+	return rid, nil
+}
+
+//Handler returns a http.Handler that sets the ID of the request before calling next. The ID is sent in the response header, is available through RequestIDFrom and is included by LoggingHandler, Logger and StructuredLogger.
+func (rid *RequestID) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(rid.header)
+		if !validRequestID(id, rid.maxLength) {
+			id = rid.generate()
+		}
+		w.Header().Set(rid.header, id)
+		if info := requestInfoFrom(r.Context()); info != nil {
+			info.requestID = id
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDContextKey, id)))
+	})
+}
+
+// validRequestID reports whether id is at most maxLength characters long and only contains letters, digits and the characters - _ . :
+func validRequestID(id string, maxLength int) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+//RequestIDFrom returns the ID of the request set by RequestID, if no ID is found it returns an error
+func RequestIDFrom(ctx context.Context) (string, error) {
+	if id, ok := ctx.Value(requestIDContextKey).(string); ok {
+		return id, nil
+	}
+	return "", errors.New("no request id found in context")
+}
+
+//NewUUIDv7 returns a random, time ordered UUID as described in RFC 9562
+func NewUUIDv7() string {
+	var u [16]byte
+	rand.Read(u[6:])
+	ms := uint64(time.Now().UnixMilli())
+	binary.BigEndian.PutUint16(u[0:], uint16(ms>>32))
+	binary.BigEndian.PutUint32(u[2:], uint32(ms))
+	u[6] = u[6]&0x0f | 0x70 // version 7
+	u[8] = u[8]&0x3f | 0x80 // RFC 9562 variant
+
+	var b [36]byte
+	hex.Encode(b[0:8], u[0:4])
+	hex.Encode(b[9:13], u[4:6])
+	hex.Encode(b[14:18], u[6:8])
+	hex.Encode(b[19:23], u[8:10])
+	hex.Encode(b[24:], u[10:])
+	b[8], b[13], b[18], b[23] = '-', '-', '-', '-'
+	return string(b[:])
+}
+
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+//NewULID returns a random, lexicographically sortable ULID as described in https://github.com/ulid/spec
+func NewULID() string {
+	var u [16]byte
+	rand.Read(u[6:])
+	ms := uint64(time.Now().UnixMilli())
+	binary.BigEndian.PutUint16(u[0:], uint16(ms>>32))
+	binary.BigEndian.PutUint32(u[2:], uint32(ms))
+
+	// encode the 128 bits five at a time, starting with the least significant ones
+	hi, lo := binary.BigEndian.Uint64(u[:8]), binary.BigEndian.Uint64(u[8:])
+	var b [26]byte
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i] = crockfordAlphabet[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(b[:])
+}
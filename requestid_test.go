@@ -0,0 +1,150 @@
+package middlewares
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestID(t *testing.T) {
+	rid, err := NewRequestID(RequestIDOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(h http.Handler, id string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != "" {
+			req.Header.Set("X-Request-ID", id)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+	uuidv7 := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+	for name, tc := range map[string]struct {
+		incoming string
+		reused   bool
+	}{
+		"Missing":          {"", false},
+		"Valid":            {"4bf92f35-77b3-4da6.a3ce:929d_0e0e4736", true},
+		"Too long":         {strings.Repeat("a", 65), false},
+		"Invalid charset":  {"abc def", false},
+		"Header injection": {"abc\r\nSet-Cookie: x", false},
+	} {
+		var id string
+		rr := serve(rid.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var err error
+			id, err = RequestIDFrom(r.Context())
+			assert.Nil(t, err, "should be nil: %s", name)
+		})), tc.incoming)
+		assert.Equal(t, id, rr.Header().Get("X-Request-ID"), "should be equal: %s", name)
+		if tc.reused {
+			assert.Equal(t, tc.incoming, id, "should be equal: %s", name)
+		} else {
+			assert.Regexp(t, uuidv7, id, "should be a uuidv7: %s", name)
+		}
+	}
+
+	t.Run("No ID in context", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = RequestIDFrom(req.Context())
+		assert.NotNil(t, err, "should not be nil")
+	})
+
+	t.Run("Custom header and generator", func(t *testing.T) {
+		rid, err := NewRequestID(RequestIDOptions{Header: "x-correlation-id", Generator: NewULID, MaxLength: 8})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("GET", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Correlation-ID", "123456789")
+		rr := httptest.NewRecorder()
+		rid.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, req)
+		assert.Regexp(t, `^[0-9A-HJKMNP-TV-Z]{26}$`, rr.Header().Get("X-Correlation-ID"), "should be a ulid")
+	})
+
+	t.Run("Used by the access log", func(t *testing.T) {
+		var b bytes.Buffer
+		l, err := NewLogger(LoggerOptions{Formatter: JSONLogFormat, Output: &b})
+		if err != nil {
+			t.Fatal(err)
+		}
+		noop := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+		for name, h := range map[string]http.Handler{
+			"RequestID outside": rid.Handler(l.Handler(noop)),
+			"RequestID inside":  l.Handler(rid.Handler(noop)),
+		} {
+			b.Reset()
+			rr := serve(h, "")
+			var line map[string]interface{}
+			assert.Nil(t, json.Unmarshal(b.Bytes(), &line), "should be nil: %s", name)
+			assert.Equal(t, rr.Header().Get("X-Request-ID"), line["request_id"], "should be equal: %s", name)
+		}
+	})
+
+	t.Run("Logged without RequestID", func(t *testing.T) {
+		var b bytes.Buffer
+		l, err := NewLogger(LoggerOptions{Formatter: JSONLogFormat, Output: &b})
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		for id, logged := range map[string]bool{"abc-123": true, strings.Repeat("a", 65): false, "abc\"def": false} {
+			b.Reset()
+			serve(h, id)
+			var line map[string]interface{}
+			assert.Nil(t, json.Unmarshal(b.Bytes(), &line), "should be nil")
+			_, ok := line["request_id"]
+			assert.Equal(t, logged, ok, "should be equal: %q", id)
+		}
+	})
+}
+
+func TestRequestIDGenerators(t *testing.T) {
+	for name, gen := range map[string]func() string{"UUIDv7": NewUUIDv7, "ULID": NewULID} {
+		ids := make([]string, 100)
+		seen := map[string]bool{}
+		for i := range ids {
+			ids[i] = gen()
+			seen[ids[i]] = true
+		}
+		assert.Len(t, seen, len(ids), "should be unique: %s", name)
+		// IDs of different milliseconds sort in the order they were generated
+		first, last := ids[0][:8], ids[len(ids)-1][:8]
+		assert.True(t, sort.StringsAreSorted([]string{first, last}), "should be sorted: %s", name)
+	}
+}
+
+func ExampleRequestID() {
+	rid, err := NewRequestID(RequestIDOptions{Generator: NewULID})
+	if err != nil {
+		// error handling
+	}
+	defaultHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := RequestIDFrom(r.Context())
+		if err != nil {
+			// error handling
+		}
+		_ = id
+	})
+
+	http.Handle("/", LoggingHandler(rid.Handler(defaultHandler)))
+	http.ListenAndServe(":3000", nil)
+}
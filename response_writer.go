@@ -0,0 +1,245 @@
+package middlewares
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+)
+
+//ResponseWriter is the http.ResponseWriter wrapper used by the handlers in this package. It records the response written through it, and implements exactly those of http.Flusher, http.Hijacker, http.Pusher and io.ReaderFrom that the wrapped writer implements, so streaming and websocket upgrades keep working behind the handlers.
+type ResponseWriter interface {
+	http.ResponseWriter
+	Status() int                 // status code of the response, http.StatusOK if the header hasn't been written yet
+	BytesWritten() int64         // number of bytes written to the body
+	WroteHeader() bool           // reports whether the header has been written
+	Unwrap() http.ResponseWriter // returns the wrapped writer, as used by http.ResponseController
+}
+
+//WrapResponseWriter returns w wrapped in a ResponseWriter
+func WrapResponseWriter(w http.ResponseWriter) ResponseWriter {
+	return newResponseRecorder(w).wrap()
+}
+
+// responseRecorder implements ResponseWriter, it's wrapped by wrap to add the optional interfaces of the underlying writer
+type responseRecorder struct {
+	w           http.ResponseWriter
+	status      int
+	size        int64
+	wroteHeader bool
+
+	onHeader func()            // called before the header is written, if set
+	capture  func([]byte) bool // consumes the body instead of writing it if it returns true, if set
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{w: w, status: http.StatusOK}
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.w.Header()
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	if r.wroteHeader || (code >= 100 && code < 200 && code != http.StatusSwitchingProtocols) {
+		r.w.WriteHeader(code) // informational responses may precede the final one
+		return
+	}
+	if r.onHeader != nil {
+		r.onHeader()
+	}
+	r.status = code
+	r.wroteHeader = true
+	r.w.WriteHeader(code)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	if r.capture != nil && r.capture(b) {
+		return len(b), nil
+	}
+	n, err := r.w.Write(b)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *responseRecorder) Status() int {
+	return r.status
+}
+
+func (r *responseRecorder) BytesWritten() int64 {
+	return r.size
+}
+
+func (r *responseRecorder) WroteHeader() bool {
+	return r.wroteHeader
+}
+
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.w
+}
+
+type flusher struct{ r *responseRecorder }
+
+func (f flusher) Flush() {
+	if !f.r.wroteHeader {
+		f.r.WriteHeader(http.StatusOK)
+	}
+	f.r.w.(http.Flusher).Flush()
+}
+
+type hijacker struct{ r *responseRecorder }
+
+func (h hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := h.r.w.(http.Hijacker).Hijack()
+	if err == nil && !h.r.wroteHeader {
+		h.r.status = http.StatusSwitchingProtocols
+		h.r.wroteHeader = true
+	}
+	return conn, rw, err
+}
+
+type pusher struct{ r *responseRecorder }
+
+func (p pusher) Push(target string, opts *http.PushOptions) error {
+	return p.r.w.(http.Pusher).Push(target, opts)
+}
+
+type readerFrom struct{ r *responseRecorder }
+
+func (rf readerFrom) ReadFrom(src io.Reader) (int64, error) {
+	if !rf.r.wroteHeader {
+		rf.r.WriteHeader(http.StatusOK)
+	}
+	if rf.r.capture != nil {
+		return io.Copy(writerOnly{rf.r}, src)
+	}
+	n, err := rf.r.w.(io.ReaderFrom).ReadFrom(src)
+	rf.r.size += n
+	return n, err
+}
+
+// writerOnly hides the ReadFrom method of the writer, so io.Copy doesn't call it recursively
+type writerOnly struct{ io.Writer }
+
+const (
+	hasFlusher = 1 << iota
+	hasHijacker
+	hasPusher
+	hasReaderFrom
+)
+
+// wrap returns r with the optional interfaces implemented by the underlying writer
+func (r *responseRecorder) wrap() ResponseWriter {
+	var flags int
+	if _, ok := r.w.(http.Flusher); ok {
+		flags |= hasFlusher
+	}
+	if _, ok := r.w.(http.Hijacker); ok {
+		flags |= hasHijacker
+	}
+	if _, ok := r.w.(http.Pusher); ok {
+		flags |= hasPusher
+	}
+	if _, ok := r.w.(io.ReaderFrom); ok {
+		flags |= hasReaderFrom
+	}
+
+	switch flags {
+	case hasFlusher:
+		return struct {
+			*responseRecorder
+			flusher
+		}{r, flusher{r}}
+	case hasHijacker:
+		return struct {
+			*responseRecorder
+			hijacker
+		}{r, hijacker{r}}
+	case hasFlusher | hasHijacker:
+		return struct {
+			*responseRecorder
+			flusher
+			hijacker
+		}{r, flusher{r}, hijacker{r}}
+	case hasPusher:
+		return struct {
+			*responseRecorder
+			pusher
+		}{r, pusher{r}}
+	case hasFlusher | hasPusher:
+		return struct {
+			*responseRecorder
+			flusher
+			pusher
+		}{r, flusher{r}, pusher{r}}
+	case hasHijacker | hasPusher:
+		return struct {
+			*responseRecorder
+			hijacker
+			pusher
+		}{r, hijacker{r}, pusher{r}}
+	case hasFlusher | hasHijacker | hasPusher:
+		return struct {
+			*responseRecorder
+			flusher
+			hijacker
+			pusher
+		}{r, flusher{r}, hijacker{r}, pusher{r}}
+	case hasReaderFrom:
+		return struct {
+			*responseRecorder
+			readerFrom
+		}{r, readerFrom{r}}
+	case hasFlusher | hasReaderFrom:
+		return struct {
+			*responseRecorder
+			flusher
+			readerFrom
+		}{r, flusher{r}, readerFrom{r}}
+	case hasHijacker | hasReaderFrom:
+		return struct {
+			*responseRecorder
+			hijacker
+			readerFrom
+		}{r, hijacker{r}, readerFrom{r}}
+	case hasFlusher | hasHijacker | hasReaderFrom:
+		return struct {
+			*responseRecorder
+			flusher
+			hijacker
+			readerFrom
+		}{r, flusher{r}, hijacker{r}, readerFrom{r}}
+	case hasPusher | hasReaderFrom:
+		return struct {
+			*responseRecorder
+			pusher
+			readerFrom
+		}{r, pusher{r}, readerFrom{r}}
+	case hasFlusher | hasPusher | hasReaderFrom:
+		return struct {
+			*responseRecorder
+			flusher
+			pusher
+			readerFrom
+		}{r, flusher{r}, pusher{r}, readerFrom{r}}
+	case hasHijacker | hasPusher | hasReaderFrom:
+		return struct {
+			*responseRecorder
+			hijacker
+			pusher
+			readerFrom
+		}{r, hijacker{r}, pusher{r}, readerFrom{r}}
+	case hasFlusher | hasHijacker | hasPusher | hasReaderFrom:
+		return struct {
+			*responseRecorder
+			flusher
+			hijacker
+			pusher
+			readerFrom
+		}{r, flusher{r}, hijacker{r}, pusher{r}, readerFrom{r}}
+	}
+	return r
+}
@@ -0,0 +1,113 @@
+package middlewares
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// plainWriter only implements http.ResponseWriter
+type plainWriter struct {
+	header http.Header
+	body   bytes.Buffer
+	code   int
+}
+
+func (p *plainWriter) Header() http.Header         { return p.header }
+func (p *plainWriter) WriteHeader(code int)        { p.code = code }
+func (p *plainWriter) Write(b []byte) (int, error) { return p.body.Write(b) }
+
+// hijackWriter implements http.Hijacker on top of a plainWriter
+type hijackWriter struct {
+	plainWriter
+	hijacked bool
+}
+
+func (h *hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h.hijacked = true
+	return nil, nil, nil
+}
+
+func TestWrapResponseWriter(t *testing.T) {
+	t.Run("Exposes exactly the optional interfaces", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			w                                     http.ResponseWriter
+			flusher, hijacker, pusher, readerFrom bool
+		}{
+			"Plain":    {&plainWriter{header: http.Header{}}, false, false, false, false},
+			"Hijacker": {&hijackWriter{plainWriter: plainWriter{header: http.Header{}}}, false, true, false, false},
+			"Recorder": {httptest.NewRecorder(), true, false, false, false},
+		} {
+			w := WrapResponseWriter(tc.w)
+			_, flusher := w.(http.Flusher)
+			_, hijacker := w.(http.Hijacker)
+			_, pusher := w.(http.Pusher)
+			_, readerFrom := w.(io.ReaderFrom)
+			assert.Equal(t, tc.flusher, flusher, "should be equal: %s", name)
+			assert.Equal(t, tc.hijacker, hijacker, "should be equal: %s", name)
+			assert.Equal(t, tc.pusher, pusher, "should be equal: %s", name)
+			assert.Equal(t, tc.readerFrom, readerFrom, "should be equal: %s", name)
+			assert.Equal(t, tc.w, w.Unwrap(), "should be equal: %s", name)
+		}
+	})
+
+	t.Run("Hijack", func(t *testing.T) {
+		hw := &hijackWriter{plainWriter: plainWriter{header: http.Header{}}}
+		w := WrapResponseWriter(hw)
+		w.(http.Hijacker).Hijack()
+		assert.True(t, hw.hijacked, "should be true")
+		assert.True(t, w.WroteHeader(), "should be true")
+		assert.Equal(t, http.StatusSwitchingProtocols, w.Status(), "should be equal")
+	})
+
+	t.Run("Flush sends the header", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		w := WrapResponseWriter(rr)
+		assert.False(t, w.WroteHeader(), "should be false")
+		w.(http.Flusher).Flush()
+		assert.True(t, w.WroteHeader(), "should be true")
+		assert.True(t, rr.Flushed, "should be true")
+	})
+
+	t.Run("Informational responses", func(t *testing.T) {
+		w := WrapResponseWriter(httptest.NewRecorder())
+		w.WriteHeader(http.StatusEarlyHints)
+		assert.False(t, w.WroteHeader(), "should be false")
+		w.WriteHeader(http.StatusCreated)
+		assert.Equal(t, http.StatusCreated, w.Status(), "should be equal")
+	})
+}
+
+func TestHandlersPreserveInterfaces(t *testing.T) {
+	srv := httptest.NewServer(LoggingHandler(ErrorHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: one\n\n")
+		assert.Nil(t, rc.Flush(), "should be nil")
+		_, ok := w.(http.Hijacker)
+		assert.True(t, ok, "should be true")
+		_, ok = w.(io.ReaderFrom)
+		assert.True(t, ok, "should be true")
+		io.Copy(w, strings.NewReader("data: two\n\n"))
+	}))))
+	defer srv.Close()
+
+	var b bytes.Buffer
+	SetOutput(&b)
+	res, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	srv.Close() // waits for the log line to be written
+	assert.Equal(t, "data: one\n\ndata: two\n\n", string(body), "should be equal")
+	assert.Contains(t, b.String(), `"GET / HTTP/1.1" 200 22`, "should contain size")
+}
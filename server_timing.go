@@ -0,0 +1,50 @@
+package middlewares
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+//ServerTiming is a timing contributed to the access log by a handler, named after the metrics of the Server-Timing header
+type ServerTiming struct {
+	Name        string
+	Duration    time.Duration
+	Description string
+}
+
+// serverTimings collects the timings of a request, it's placed in the context by the logging handlers
+type serverTimings struct {
+	mu      sync.Mutex
+	timings []ServerTiming
+}
+
+func withServerTimings(ctx context.Context) (context.Context, *serverTimings) {
+	t := &serverTimings{}
+	return context.WithValue(ctx, serverTimingContextKey, t), t
+}
+
+func (t *serverTimings) list() []ServerTiming {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]ServerTiming(nil), t.timings...)
+}
+
+//AddServerTiming records a timing for the request of ctx, e.g. the time spent querying a database, which is included in the log written by LoggingHandler, a Logger or a StructuredLogger. Nothing is recorded if the request isn't logged by any of them.
+func AddServerTiming(ctx context.Context, name string, d time.Duration, description string) {
+	t, ok := ctx.Value(serverTimingContextKey).(*serverTimings)
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	t.timings = append(t.timings, ServerTiming{Name: name, Duration: d, Description: description})
+	t.mu.Unlock()
+}
+
+//ServerTimingFunc returns a function that records the time passed since ServerTimingFunc was called when it's called, to be used with defer
+func ServerTimingFunc(ctx context.Context, name, description string) func() {
+	start := time.Now()
+	return func() {
+		AddServerTiming(ctx, name, time.Since(start), description)
+	}
+}
@@ -0,0 +1,531 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors returned when a request signature fails verification, use errors.Is to check for them
+var (
+	ErrSignatureMissing  = errors.New("request isn't signed")
+	ErrSignatureInvalid  = errors.New("invalid request signature")
+	ErrSignatureExpired  = errors.New("request timestamp outside tolerance")
+	ErrSignatureReplayed = errors.New("request nonce has already been used")
+	ErrBodyTooLarge      = errors.New("request body too large")
+)
+
+const (
+	defaultSignatureTolerance = 5 * time.Minute
+	defaultMaxBodySize        = 1 << 20
+)
+
+//SignatureParams are the signature and signing parameters presented by a request
+type SignatureParams struct {
+	KeyID      string            // identifies the secret, empty for schemes using a single secret
+	Signatures [][]byte          // decoded signatures, the request is valid if any of them matches
+	Timestamp  time.Time         // signing time, zero if the scheme doesn't sign a timestamp
+	Nonce      string            // single use value, empty if the scheme doesn't use one
+	Fields     map[string]string // additional scheme specific parameters
+}
+
+//SignatureScheme describes how requests are signed. Params extracts the presented signature from a request, and Sign computes the expected signature of the request using secret. Sign must not read the body of r, it's passed as body.
+type SignatureScheme interface {
+	Params(r *http.Request) (*SignatureParams, error)
+	Sign(secret []byte, r *http.Request, body []byte, p *SignatureParams) ([]byte, error)
+}
+
+//SecretResolver returns the shared secret identified by keyID
+type SecretResolver interface {
+	ResolveSecret(ctx context.Context, keyID string) ([]byte, error)
+}
+
+//StaticSecrets is a SecretResolver using a fixed set of secrets indexed by key ID. The secret stored under "" is used by schemes without key IDs.
+type StaticSecrets map[string][]byte
+
+//ResolveSecret returns the secret stored under keyID
+func (s StaticSecrets) ResolveSecret(ctx context.Context, keyID string) ([]byte, error) {
+	if secret, ok := s[keyID]; ok {
+		return secret, nil
+	}
+	return nil, fmt.Errorf("no secret found for key %q", keyID)
+}
+
+//HMACScheme is a SignatureScheme taking the signature from a single header and signing a configurable canonical string with HMAC
+type HMACScheme struct {
+	Header          string           // header holding the signature, required
+	Prefix          string           // stripped from the header value, e.g. "sha256="
+	Base64          bool             // signatures are base64 encoded instead of hex encoded
+	Hash            func() hash.Hash // defaults to sha256.New
+	KeyIDHeader     string           // header holding the key ID, if any
+	TimestampHeader string           // header holding the signing time in unix seconds, if any
+	NonceHeader     string           // header holding a nonce, if any
+
+	// Canonical returns the string to sign. It defaults to the body, preceded by the value of the timestamp header and the nonce, each followed by a newline, if those headers are set.
+	Canonical func(r *http.Request, body []byte, p *SignatureParams) []byte
+}
+
+//GitHubSignature verifies GitHub webhooks, which sign the body using the X-Hub-Signature-256 header
+var GitHubSignature SignatureScheme = &HMACScheme{Header: "X-Hub-Signature-256", Prefix: "sha256="}
+
+//Params returns the parameters found in the configured headers
+func (s *HMACScheme) Params(r *http.Request) (*SignatureParams, error) {
+	v := r.Header.Get(s.Header)
+	if v == "" {
+		return nil, ErrSignatureMissing
+	}
+	if !strings.HasPrefix(v, s.Prefix) {
+		return nil, fmt.Errorf("%w: missing %q prefix", ErrSignatureInvalid, s.Prefix)
+	}
+	sig, err := decodeSignature(v[len(s.Prefix):], s.Base64)
+	if err != nil {
+		return nil, err
+	}
+	p := &SignatureParams{Signatures: [][]byte{sig}}
+	if s.KeyIDHeader != "" {
+		p.KeyID = r.Header.Get(s.KeyIDHeader)
+	}
+	if s.NonceHeader != "" {
+		if p.Nonce = r.Header.Get(s.NonceHeader); p.Nonce == "" {
+			return nil, fmt.Errorf("%w: missing %s header", ErrSignatureInvalid, s.NonceHeader)
+		}
+	}
+	if s.TimestampHeader != "" {
+		if p.Timestamp, err = parseUnixTime(r.Header.Get(s.TimestampHeader)); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+//Sign returns the HMAC of the canonical string of r
+func (s *HMACScheme) Sign(secret []byte, r *http.Request, body []byte, p *SignatureParams) ([]byte, error) {
+	h := s.Hash
+	if h == nil {
+		h = sha256.New
+	}
+	if s.Canonical != nil {
+		return hmacSum(h, secret, s.Canonical(r, body, p)), nil
+	}
+	return hmacSum(h, secret, s.canonical(r, body, p)), nil
+}
+
+// canonical returns the default string to sign, which covers the timestamp and nonce so that they can't be replaced in a replayed request
+func (s *HMACScheme) canonical(r *http.Request, body []byte, p *SignatureParams) []byte {
+	if s.TimestampHeader == "" && s.NonceHeader == "" {
+		return body
+	}
+	var b bytes.Buffer
+	if s.TimestampHeader != "" {
+		b.WriteString(r.Header.Get(s.TimestampHeader) + "\n")
+	}
+	if s.NonceHeader != "" {
+		b.WriteString(p.Nonce + "\n")
+	}
+	b.Write(body)
+	return b.Bytes()
+}
+
+//StripeSignature verifies Stripe webhooks, which sign the timestamp and body using the Stripe-Signature header
+var StripeSignature SignatureScheme = stripeScheme{}
+
+type stripeScheme struct{}
+
+func (stripeScheme) Params(r *http.Request) (*SignatureParams, error) {
+	v := r.Header.Get("Stripe-Signature")
+	if v == "" {
+		return nil, ErrSignatureMissing
+	}
+	p := &SignatureParams{}
+	var ts string
+	for _, part := range strings.Split(v, ",") {
+		k, val, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			ts = val
+		case "v1":
+			sig, err := decodeSignature(val, false)
+			if err != nil {
+				return nil, err
+			}
+			p.Signatures = append(p.Signatures, sig)
+		}
+	}
+	if len(p.Signatures) == 0 {
+		return nil, fmt.Errorf("%w: no v1 signature", ErrSignatureInvalid)
+	}
+	var err error
+	if p.Timestamp, err = parseUnixTime(ts); err != nil {
+		return nil, err
+	}
+	p.Fields = map[string]string{"t": ts}
+	return p, nil
+}
+
+func (stripeScheme) Sign(secret []byte, r *http.Request, body []byte, p *SignatureParams) ([]byte, error) {
+	return hmacSum(sha256.New, secret, append([]byte(p.Fields["t"]+"."), body...)), nil
+}
+
+//SigV4Scheme is a SignatureScheme verifying requests signed like AWS Signature Version 4, using the Authorization and X-Amz-Date headers. The access key ID of the credential is used as key ID.
+type SigV4Scheme struct {
+	// S3 encodes each path segment once and leaves the path unnormalized, as Amazon S3 does. Otherwise the path is normalized and its segments are encoded twice, as all other AWS services do.
+	S3 bool
+}
+
+//SigV4Signature verifies requests signed like AWS Signature Version 4 for services other than Amazon S3
+var SigV4Signature SignatureScheme = &SigV4Scheme{}
+
+//SigV4S3Signature verifies requests signed like AWS Signature Version 4 for Amazon S3
+var SigV4S3Signature SignatureScheme = &SigV4Scheme{S3: true}
+
+const sigV4Algorithm = "AWS4-HMAC-SHA256"
+
+//Params returns the parameters found in the Authorization and X-Amz-Date headers
+func (s *SigV4Scheme) Params(r *http.Request) (*SignatureParams, error) {
+	scheme, rest, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if scheme != sigV4Algorithm {
+		return nil, ErrSignatureMissing
+	}
+	fields := map[string]string{}
+	for _, part := range strings.Split(rest, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		fields[k] = v
+	}
+	cred := strings.SplitN(fields["Credential"], "/", 2)
+	if len(cred) != 2 || fields["SignedHeaders"] == "" {
+		return nil, fmt.Errorf("%w: malformed authorization", ErrSignatureInvalid)
+	}
+	sig, err := decodeSignature(fields["Signature"], false)
+	if err != nil {
+		return nil, err
+	}
+	ts, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed X-Amz-Date", ErrSignatureInvalid)
+	}
+	scope := strings.Split(cred[1], "/")
+	if len(scope) != 4 || scope[0] != ts.Format("20060102") || scope[3] != "aws4_request" {
+		return nil, fmt.Errorf("%w: malformed credential scope", ErrSignatureInvalid)
+	}
+	return &SignatureParams{
+		KeyID:      cred[0],
+		Signatures: [][]byte{sig},
+		Timestamp:  ts,
+		Fields: map[string]string{
+			"scope":         cred[1],
+			"date":          scope[0],
+			"region":        scope[1],
+			"service":       scope[2],
+			"signedHeaders": fields["SignedHeaders"],
+			"amzDate":       r.Header.Get("X-Amz-Date"),
+		},
+	}, nil
+}
+
+//Sign returns the signature of the canonical request of r
+func (s *SigV4Scheme) Sign(secret []byte, r *http.Request, body []byte, p *SignatureParams) ([]byte, error) {
+	signed := strings.Split(p.Fields["signedHeaders"], ";")
+	if !containsString(signed, "host") {
+		return nil, fmt.Errorf("%w: host header isn't signed", ErrSignatureInvalid)
+	}
+	var headers strings.Builder
+	for _, name := range signed {
+		values := r.Header.Values(name)
+		if name == "host" {
+			values = []string{r.Host}
+		}
+		trimmed := make([]string, len(values))
+		for i, v := range values {
+			trimmed[i] = strings.Join(strings.Fields(v), " ")
+		}
+		headers.WriteString(name + ":" + strings.Join(trimmed, ",") + "\n")
+	}
+	payload := sha256.Sum256(body)
+	canonical := strings.Join([]string{
+		r.Method,
+		sigV4Path(r.URL.EscapedPath(), !s.S3),
+		sigV4Query(r.URL.Query()),
+		headers.String(),
+		p.Fields["signedHeaders"],
+		hex.EncodeToString(payload[:]),
+	}, "\n")
+	hashed := sha256.Sum256([]byte(canonical))
+	toSign := strings.Join([]string{sigV4Algorithm, p.Fields["amzDate"], p.Fields["scope"], hex.EncodeToString(hashed[:])}, "\n")
+
+	key := []byte("AWS4" + string(secret))
+	for _, part := range []string{p.Fields["date"], p.Fields["region"], p.Fields["service"], "aws4_request"} {
+		key = hmacSum(sha256.New, key, []byte(part))
+	}
+	return hmacSum(sha256.New, key, []byte(toSign)), nil
+}
+
+// sigV4Path returns the canonical URI of the escaped path p. Its segments are unescaped and encoded again, so that the result doesn't depend on how the client escaped them. If double is set, dot segments and empty segments are removed and the segments are encoded a second time.
+func sigV4Path(p string, double bool) string {
+	parts := strings.Split(strings.TrimPrefix(p, "/"), "/")
+	var segments []string
+	for i, seg := range parts {
+		if v, err := url.PathUnescape(seg); err == nil {
+			seg = v
+		}
+		if double {
+			last := i == len(parts)-1
+			switch seg {
+			case "..":
+				if len(segments) > 0 {
+					segments = segments[:len(segments)-1]
+				}
+				fallthrough
+			case ".", "":
+				if last {
+					segments = append(segments, "") // keep the trailing slash
+				}
+				continue
+			}
+			seg = sigV4Escape(seg)
+		}
+		segments = append(segments, sigV4Escape(seg))
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+// sigV4Query returns the canonical query string of q, sorted by the encoded keys and then by the encoded values
+func sigV4Query(q url.Values) string {
+	type pair struct{ k, v string }
+	var pairs []pair
+	for k, vs := range q {
+		for _, v := range vs {
+			pairs = append(pairs, pair{sigV4Escape(k), sigV4Escape(v)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].k != pairs[j].k {
+			return pairs[i].k < pairs[j].k
+		}
+		return pairs[i].v < pairs[j].v
+	})
+	encoded := make([]string, len(pairs))
+	for i, p := range pairs {
+		encoded[i] = p.k + "=" + p.v
+	}
+	return strings.Join(encoded, "&")
+}
+
+// sigV4Escape percent encodes every byte of s except the unreserved characters of RFC 3986
+func sigV4Escape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func hmacSum(h func() hash.Hash, key, msg []byte) []byte {
+	m := hmac.New(h, key)
+	m.Write(msg)
+	return m.Sum(nil)
+}
+
+func decodeSignature(s string, b64 bool) ([]byte, error) {
+	var sig []byte
+	var err error
+	if b64 {
+		sig, err = base64.StdEncoding.DecodeString(s)
+	} else {
+		sig, err = hex.DecodeString(s)
+	}
+	if err != nil || len(sig) == 0 {
+		return nil, fmt.Errorf("%w: malformed signature", ErrSignatureInvalid)
+	}
+	return sig, nil
+}
+
+func parseUnixTime(s string) (time.Time, error) {
+	sec, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: malformed timestamp", ErrSignatureInvalid)
+	}
+	return time.Unix(sec, 0), nil
+}
+
+//NonceCache remembers the nonces of verified requests. UseNonce returns ErrSignatureReplayed if nonce has been used before, otherwise it remembers nonce until expires.
+type NonceCache interface {
+	UseNonce(ctx context.Context, nonce string, expires time.Time) error
+}
+
+// nonceCachePruneInterval is how often memoryNonceCache removes expired nonces
+const nonceCachePruneInterval = time.Minute
+
+// memoryNonceCache is the NonceCache used by default, expired nonces are pruned at most once per nonceCachePruneInterval when new ones are added
+type memoryNonceCache struct {
+	mu        sync.Mutex
+	nonces    map[string]time.Time
+	nextPrune time.Time
+	now       func() time.Time
+}
+
+func (c *memoryNonceCache) UseNonce(ctx context.Context, nonce string, expires time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if exp, ok := c.nonces[nonce]; ok && now.Before(exp) {
+		return ErrSignatureReplayed
+	}
+	if !now.Before(c.nextPrune) {
+		for n, exp := range c.nonces {
+			if !now.Before(exp) {
+				delete(c.nonces, n)
+			}
+		}
+		c.nextPrune = now.Add(nonceCachePruneInterval)
+	}
+	c.nonces[nonce] = expires
+	return nil
+}
+
+//SignatureOptions holds the settings used to build a SignatureVerifier
+type SignatureOptions struct {
+	Scheme         SignatureScheme // how requests are signed, e.g. GitHubSignature, required
+	Secrets        SecretResolver  // resolves the shared secrets, required
+	Tolerance      time.Duration   // how far the signed timestamp may differ from the current time, defaults to 5 minutes
+	NonceCache     NonceCache      // remembers nonces to reject replayed requests, defaults to an in-memory cache
+	MaxBodySize    int64           // largest body that is buffered for verification, defaults to 1 MiB
+	Realm          string          // realm sent in the WWW-Authenticate challenge
+	ErrorResponder ErrorResponder  // answers rejected requests, defaults to DefaultErrorResponder
+}
+
+//SignatureVerifier authenticates requests signed with a shared secret using HMAC, such as webhooks and service-to-service calls
+type SignatureVerifier struct {
+	scheme      SignatureScheme
+	secrets     SecretResolver
+	tolerance   time.Duration
+	nonces      NonceCache
+	maxBodySize int64
+	realm       string
+	respond     ErrorResponder
+	now         func() time.Time
+}
+
+//NewSignatureVerifier returns a SignatureVerifier using the settings in opts, or an error if no scheme or secrets are given
+func NewSignatureVerifier(opts SignatureOptions) (*SignatureVerifier, error) {
+	if opts.Scheme == nil || opts.Secrets == nil {
+		return nil, errors.New("signature verifier requires a scheme and secrets")
+	}
+	v := &SignatureVerifier{
+		scheme:      opts.Scheme,
+		secrets:     opts.Secrets,
+		tolerance:   opts.Tolerance,
+		nonces:      opts.NonceCache,
+		maxBodySize: opts.MaxBodySize,
+		realm:       opts.Realm,
+		respond:     opts.ErrorResponder,
+		now:         time.Now,
+	}
+	if v.tolerance <= 0 {
+		v.tolerance = defaultSignatureTolerance
+	}
+	if v.maxBodySize <= 0 {
+		v.maxBodySize = defaultMaxBodySize
+	}
+	if v.respond == nil {
+		v.respond = DefaultErrorResponder
+	}
+	if v.nonces == nil {
+		v.nonces = &memoryNonceCache{nonces: map[string]time.Time{}, now: func() time.Time { return v.now() }}
+	}
+	return v, nil
+}
+
+//Verify checks the signature of r and returns its parameters if it's valid. The body of r is read and replaced, so it can still be read by the caller.
+func (v *SignatureVerifier) Verify(r *http.Request) (*SignatureParams, error) {
+	body, err := v.readBody(r)
+	if err != nil {
+		return nil, err
+	}
+	p, err := v.scheme.Params(r)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := v.secrets.ResolveSecret(r.Context(), p.KeyID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSignatureInvalid, err.Error())
+	}
+	expected, err := v.scheme.Sign(secret, r, body, p)
+	if err != nil {
+		return nil, err
+	}
+	var match bool
+	for _, sig := range p.Signatures {
+		if hmac.Equal(sig, expected) {
+			match = true
+		}
+	}
+	if !match {
+		return nil, ErrSignatureInvalid
+	}
+
+	now := v.now()
+	expires := now.Add(v.tolerance)
+	if !p.Timestamp.IsZero() {
+		if d := now.Sub(p.Timestamp); d > v.tolerance || d < -v.tolerance {
+			return nil, ErrSignatureExpired
+		}
+		expires = p.Timestamp.Add(v.tolerance)
+	}
+	if p.Nonce != "" {
+		if err := v.nonces.UseNonce(r.Context(), p.KeyID+":"+p.Nonce, expires); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// readBody buffers the body of r and replaces it with the buffered copy
+func (v *SignatureVerifier) readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, v.maxBodySize+1))
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > v.maxBodySize {
+		return nil, ErrBodyTooLarge
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+//Handler returns a http.Handler that verifies the signature of the request before calling next. On success the signing key ID is available as subject through PrincipalFrom, and the body can be read as usual. Requests without a valid signature are answered with 401 Unauthorized, and bodies larger than MaxBodySize with 413 Request Entity Too Large.
+func (v *SignatureVerifier) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := v.Verify(r)
+		if errors.Is(err, ErrBodyTooLarge) {
+			v.respond(w, r, http.StatusRequestEntityTooLarge, "", err)
+			return
+		}
+		if err != nil {
+			challenge := "Signature"
+			if v.realm != "" {
+				challenge += " realm=" + quoteParam(v.realm)
+			}
+			v.respond(w, r, http.StatusUnauthorized, challenge, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(withPrincipal(r.Context(), &Identity{
+			Name:   p.KeyID,
+			Method: AuthMethodSignature,
+			Attrs:  map[string]string{"key_id": p.KeyID, "nonce": p.Nonce},
+		})))
+	})
+}
@@ -0,0 +1,269 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGitHubSignature(t *testing.T) {
+	v, err := NewSignatureVerifier(SignatureOptions{Scheme: GitHubSignature, Secrets: StaticSecrets{"": []byte("It's a Secret to Everybody")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newRequest := func(sig string) *http.Request {
+		req, err := http.NewRequest("POST", "/hooks", strings.NewReader("Hello, World!"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sig != "" {
+			req.Header.Set("X-Hub-Signature-256", sig)
+		}
+		return req
+	}
+
+	t.Run("Valid signature restores body", func(t *testing.T) {
+		var body string
+		rr := httptest.NewRecorder()
+		v.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, _ := io.ReadAll(r.Body)
+			body = string(b)
+			p, err := PrincipalFrom(r.Context())
+			assert.Nil(t, err, "should be nil")
+			assert.Equal(t, AuthMethodSignature, p.AuthMethod(), "should be equal")
+		})).ServeHTTP(rr, newRequest("sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"))
+		assert.Equal(t, http.StatusOK, rr.Code, "should be equal")
+		assert.Equal(t, "Hello, World!", body, "should be equal")
+	})
+
+	t.Run("Invalid signatures", func(t *testing.T) {
+		for sig, want := range map[string]error{
+			"": ErrSignatureMissing,
+			"sha256=0000000000000000000000000000000000000000000000000000000000000000": ErrSignatureInvalid,
+			"sha1=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17":   ErrSignatureInvalid,
+			"sha256=nothex": ErrSignatureInvalid,
+		} {
+			_, err := v.Verify(newRequest(sig))
+			assert.True(t, errors.Is(err, want), "should be true: %v", err)
+		}
+
+		rr := httptest.NewRecorder()
+		v.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, newRequest(""))
+		assert.Equal(t, http.StatusUnauthorized, rr.Code, "should be equal")
+	})
+
+	t.Run("Body too large", func(t *testing.T) {
+		v, err := NewSignatureVerifier(SignatureOptions{Scheme: GitHubSignature, Secrets: StaticSecrets{"": []byte("secret")}, MaxBodySize: 4})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		v.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, newRequest("sha256=00"))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code, "should be equal")
+	})
+}
+
+func TestStripeSignature(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	secret := []byte("whsec_test")
+	v, err := NewSignatureVerifier(SignatureOptions{Scheme: StripeSignature, Secrets: StaticSecrets{"": secret}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.now = func() time.Time { return now }
+
+	sign := func(ts time.Time, body string) string {
+		t := strconv.FormatInt(ts.Unix(), 10)
+		return "t=" + t + ",v1=" + hex.EncodeToString(hmacSum(sha256.New, secret, []byte(t+"."+body))) + ",v0=ignored"
+	}
+	verify := func(header string) error {
+		req, err := http.NewRequest("POST", "/stripe", strings.NewReader(`{"id":"evt_1"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Stripe-Signature", header)
+		_, err = v.Verify(req)
+		return err
+	}
+
+	assert.Nil(t, verify(sign(now.Add(-time.Minute), `{"id":"evt_1"}`)), "should be nil")
+	assert.True(t, errors.Is(verify(sign(now.Add(-time.Hour), `{"id":"evt_1"}`)), ErrSignatureExpired), "should be true")
+	assert.True(t, errors.Is(verify(sign(now.Add(time.Hour), `{"id":"evt_1"}`)), ErrSignatureExpired), "should be true")
+	assert.True(t, errors.Is(verify(sign(now, `{"id":"evt_2"}`)), ErrSignatureInvalid), "should be true")
+	assert.True(t, errors.Is(verify("t=abc,v1=00"), ErrSignatureInvalid), "should be true")
+}
+
+func TestSigV4Signature(t *testing.T) {
+	// vectors from the AWS Signature Version 4 test suite, whose request lines hold unescaped paths, so the S3 scheme encoding them once matches them
+	tests := []struct {
+		name   string
+		scheme SignatureScheme
+		url    string
+		sig    string
+	}{
+		{"get-vanilla", SigV4Signature, "/", "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"get-vanilla-query-order-key-case", SigV4Signature, "/?Param2=value2&Param1=value1", "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500"},
+		{"get-vanilla-query-order-value", SigV4Signature, "/?Param1=value2&Param1=value1", "5772eed61e12b33fae39ee5e7012498b51d56abc0abb7c60486157bd471c4694"},
+		{"get-vanilla-query-unreserved", SigV4Signature, "/?-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz=-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz", "9c3e54bfcdf0b19771a7f523ee5669cdf59bc7cc0884027167c21bb143a40197"},
+		{"get-slashes", SigV4Signature, "//example//", "9a624bd73a37c9a373b5312afbebe7a714a789de108f0bdfe846570885f57e84"},
+		{"get-relative-relative", SigV4Signature, "/example1/example2/../..", "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"get-utf8", SigV4S3Signature, "/%E1%88%B4", "8318018e0b0f223aa2bbf98705b62bb787dc9c0e678f255a891fd03141be5d85"},
+		{"get-space", SigV4S3Signature, "/example%20space/", "652487583200325589f1fba4c7e578f72c47cb61beeca81406b39ddec1366741"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := NewSignatureVerifier(SignatureOptions{Scheme: tt.scheme, Secrets: StaticSecrets{"AKIDEXAMPLE": []byte("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			v.now = func() time.Time { return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC) }
+
+			newRequest := func(sig string) *http.Request {
+				req, err := http.NewRequest("GET", "http://example.amazonaws.com"+tt.url, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				req.Header.Set("X-Amz-Date", "20150830T123600Z")
+				req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature="+sig)
+				return req
+			}
+
+			p, err := v.Verify(newRequest(tt.sig))
+			assert.Nil(t, err, "should be nil")
+			if assert.NotNil(t, p, "should not be nil") {
+				assert.Equal(t, "AKIDEXAMPLE", p.KeyID, "should be equal")
+			}
+
+			_, err = v.Verify(newRequest("0" + tt.sig[1:]))
+			assert.True(t, errors.Is(err, ErrSignatureInvalid), "should be true")
+		})
+	}
+}
+
+func TestSigV4Canonical(t *testing.T) {
+	t.Run("Query", func(t *testing.T) {
+		q := url.Values{"a": {"1"}, "a1": {"2"}, "a-b": {"3"}, "b": {"y z", "x+"}}
+		assert.Equal(t, "a=1&a-b=3&a1=2&b=x%2B&b=y%20z", sigV4Query(q), "should be equal")
+	})
+	t.Run("Path", func(t *testing.T) {
+		assert.Equal(t, "/documents%2520and%2520settings/", sigV4Path("/documents%20and%20settings/", true), "should be equal")
+		assert.Equal(t, "/documents%20and%20settings/", sigV4Path("/documents and settings/", false), "should be equal")
+		assert.Equal(t, "/a/c/", sigV4Path("/a//b/.././c/", true), "should be equal")
+		assert.Equal(t, "/a//b/.././c/", sigV4Path("/a//b/.././c/", false), "should be equal")
+		assert.Equal(t, "/a%252Fb", sigV4Path("/a%2Fb", true), "should be equal")
+		assert.Equal(t, "/", sigV4Path("", true), "should be equal")
+		assert.Equal(t, "/", sigV4Path("", false), "should be equal")
+	})
+}
+
+func TestSignatureNonce(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	secret := []byte("s3cret")
+	scheme := &HMACScheme{
+		Header:          "X-Signature",
+		KeyIDHeader:     "X-Key-Id",
+		TimestampHeader: "X-Timestamp",
+		NonceHeader:     "X-Nonce",
+		Canonical: func(r *http.Request, body []byte, p *SignatureParams) []byte {
+			return []byte(strings.Join([]string{r.Method, r.URL.Path, r.Header.Get("X-Timestamp"), p.Nonce, string(body)}, "\n"))
+		},
+	}
+	v, err := NewSignatureVerifier(SignatureOptions{Scheme: scheme, Secrets: StaticSecrets{"orders": secret}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.now = func() time.Time { return now }
+
+	newRequest := func(nonce string) *http.Request {
+		req, err := http.NewRequest("PUT", "/orders/1", strings.NewReader("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts := strconv.FormatInt(now.Unix(), 10)
+		req.Header.Set("X-Key-Id", "orders")
+		req.Header.Set("X-Timestamp", ts)
+		req.Header.Set("X-Nonce", nonce)
+		req.Header.Set("X-Signature", hex.EncodeToString(hmacSum(sha256.New, secret, []byte("PUT\n/orders/1\n"+ts+"\n"+nonce+"\n{}"))))
+		return req
+	}
+
+	p, err := v.Verify(newRequest("n1"))
+	assert.Nil(t, err, "should be nil")
+	assert.Equal(t, "orders", p.KeyID, "should be equal")
+
+	_, err = v.Verify(newRequest("n1"))
+	assert.True(t, errors.Is(err, ErrSignatureReplayed), "should be true")
+
+	_, err = v.Verify(newRequest("n2"))
+	assert.Nil(t, err, "should be nil")
+
+	c := v.nonces.(*memoryNonceCache)
+	c.now = func() time.Time { return now.Add(10 * time.Minute) }
+	assert.Nil(t, c.UseNonce(context.Background(), "orders:n1", now.Add(10*time.Minute+time.Second)), "should be nil")
+	assert.Len(t, c.nonces, 1, "should be pruned")
+
+	c.now = func() time.Time { return now.Add(10*time.Minute + 30*time.Second) }
+	assert.Nil(t, c.UseNonce(context.Background(), "orders:n3", now.Add(time.Hour)), "should be nil")
+	assert.Len(t, c.nonces, 2, "should not be pruned before the prune interval has passed")
+	assert.Nil(t, c.UseNonce(context.Background(), "orders:n1", now.Add(time.Hour)), "should be nil: expired nonce")
+}
+
+func TestSignatureDefaultCanonical(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	secret := []byte("s3cret")
+	scheme := &HMACScheme{Header: "X-Signature", TimestampHeader: "X-Timestamp", NonceHeader: "X-Nonce"}
+	v, err := NewSignatureVerifier(SignatureOptions{Scheme: scheme, Secrets: StaticSecrets{"": secret}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.now = func() time.Time { return now }
+
+	ts := strconv.FormatInt(now.Unix(), 10)
+	sig := hex.EncodeToString(hmacSum(sha256.New, secret, []byte(ts+"\na\n{}")))
+	newRequest := func(ts, nonce string) *http.Request {
+		req, err := http.NewRequest("POST", "/hooks", strings.NewReader("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Timestamp", ts)
+		req.Header.Set("X-Nonce", nonce)
+		req.Header.Set("X-Signature", sig)
+		return req
+	}
+
+	_, err = v.Verify(newRequest(ts, "a"))
+	assert.Nil(t, err, "should be nil")
+
+	_, err = v.Verify(newRequest(ts, "b"))
+	assert.True(t, errors.Is(err, ErrSignatureInvalid), "should be true: replaced nonce")
+
+	_, err = v.Verify(newRequest(strconv.FormatInt(now.Unix()+1, 10), "a"))
+	assert.True(t, errors.Is(err, ErrSignatureInvalid), "should be true: replaced timestamp")
+}
+
+func ExampleSignatureVerifier() {
+	v, err := NewSignatureVerifier(SignatureOptions{
+		Scheme:  GitHubSignature,
+		Secrets: StaticSecrets{"": []byte("webhook secret")},
+	})
+	if err != nil {
+		// error handling
+	}
+	hookHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the body is verified and can be read as usual
+	})
+
+	http.Handle("/hooks/github", v.Handler(hookHandler))
+	http.ListenAndServe(":3000", nil)
+}
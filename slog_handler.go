@@ -0,0 +1,100 @@
+package middlewares
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+//StructuredLoggerOptions holds the settings used to build a StructuredLogger
+type StructuredLoggerOptions struct {
+	Logger  *slog.Logger       // receives the records, required
+	Message string             // message of the records, defaults to "request"
+	Levels  map[int]slog.Level // level of the records by status class, e.g. 5 for 5xx responses. Defaults to Error for 5xx, Warn for 4xx and Info for everything else, classes missing from the map are logged at Info.
+
+	// ReplaceAttr is called for each attribute before it's logged, like slog.HandlerOptions.ReplaceAttr. Attributes are dropped if it returns an attribute with an empty key.
+	ReplaceAttr func(a slog.Attr) slog.Attr
+}
+
+//StructuredLogger logs one structured record per request to a *slog.Logger. Records have the attributes method, path, status, bytes, duration, ttfb, remote_ip, and request_id, trace_id, span_id, user and a server_timing group if they are known.
+type StructuredLogger struct {
+	logger  *slog.Logger
+	message string
+	levels  map[int]slog.Level
+	replace func(a slog.Attr) slog.Attr
+	now     func() time.Time
+}
+
+var defaultStatusLevels = map[int]slog.Level{4: slog.LevelWarn, 5: slog.LevelError}
+
+//NewStructuredLogger returns a StructuredLogger using the settings in opts, or an error if no logger is given
+func NewStructuredLogger(opts StructuredLoggerOptions) (*StructuredLogger, error) {
+	if opts.Logger == nil {
+		return nil, errors.New("structured logger requires a *slog.Logger")
+	}
+	l := &StructuredLogger{
+		logger:  opts.Logger,
+		message: opts.Message,
+		levels:  opts.Levels,
+		replace: opts.ReplaceAttr,
+		now:     time.Now,
+	}
+	if l.message == "" {
+		l.message = "request"
+	}
+	if l.levels == nil {
+		l.levels = defaultStatusLevels
+	}
+	return l, nil
+}
+
+//Handler returns a http.Handler that wraps next, and logs each request after next has served it. The record is logged with the context of the request, so slog handlers can add values from it.
+func (l *StructuredLogger) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := serveLogged(next, w, r, l.now)
+		level := l.levels[e.Status/100]
+		if !l.logger.Enabled(r.Context(), level) {
+			return
+		}
+		l.logger.LogAttrs(r.Context(), level, l.message, l.attrs(e)...)
+	})
+}
+
+func (l *StructuredLogger) attrs(e *LogEntry) []slog.Attr {
+	attrs := []slog.Attr{
+		slog.String("method", e.Request.Method),
+		slog.String("path", e.Request.URL.Path),
+		slog.Int("status", e.Status),
+		slog.Int("bytes", e.Size),
+		slog.Duration("duration", e.Duration),
+		slog.Duration("ttfb", e.TimeToFirstByte),
+		slog.String("remote_ip", remoteHost(e.Request)),
+	}
+	if e.RequestID != "" {
+		attrs = append(attrs, slog.String("request_id", e.RequestID))
+	}
+	if e.TraceID != "" {
+		attrs = append(attrs, slog.String("trace_id", e.TraceID), slog.String("span_id", e.SpanID))
+	}
+	if e.User != "" {
+		attrs = append(attrs, slog.String("user", e.User))
+	}
+	if len(e.ServerTiming) > 0 {
+		timings := make([]any, len(e.ServerTiming))
+		for i, st := range e.ServerTiming {
+			timings[i] = slog.Duration(st.Name, st.Duration)
+		}
+		attrs = append(attrs, slog.Group("server_timing", timings...))
+	}
+	if l.replace == nil {
+		return attrs
+	}
+	kept := attrs[:0]
+	for _, a := range attrs {
+		if a = l.replace(a); a.Key != "" {
+			kept = append(kept, a)
+		}
+	}
+	return kept
+}
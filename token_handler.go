@@ -4,20 +4,193 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"mime"
 	"net/http"
 	"strings"
 )
 
-//TokenHandler extracts any Bearer tokens from the request
+//TokenHandler extracts any Bearer tokens from the Authorization header of the request. Use a TokenReader to look for tokens elsewhere.
 func TokenHandler(next http.Handler) http.Handler {
+	return defaultTokenReader.Handler(next)
+}
+
+// Errors returned by TokenReader.Extract, use errors.Is to check for them
+var (
+	ErrTokenMissing   = errors.New("no token found in request")
+	ErrMultipleTokens = errors.New("token found in more than one location") // RFC 6750 forbids presenting a token in more than one location
+)
+
+//TokenExtractor looks for a token at one location of a request. It returns an empty token and a nil error if the location doesn't hold a token, and an error if it holds a malformed one.
+type TokenExtractor func(r *http.Request) (token string, err error)
+
+//HeaderToken returns a TokenExtractor that reads the token from the header name. If scheme isn't empty the header value must start with it, e.g. HeaderToken("Authorization", "Bearer"), and headers using other schemes are ignored. The scheme is matched case-insensitively.
+func HeaderToken(name, scheme string) TokenExtractor {
+	return func(r *http.Request) (string, error) {
+		values := r.Header.Values(name)
+		var token string
+		for _, v := range values {
+			if scheme != "" {
+				s, rest, _ := strings.Cut(v, " ")
+				if !strings.EqualFold(s, scheme) {
+					continue
+				}
+				v = strings.TrimLeft(rest, " ")
+				if v == "" {
+					return "", fmt.Errorf("empty %s token", strings.ToLower(scheme))
+				}
+			}
+			if token != "" {
+				return "", ErrMultipleTokens
+			}
+			token = v
+		}
+		return token, nil
+	}
+}
+
+//QueryToken returns a TokenExtractor that reads the token from the URL query parameter param, e.g. "access_token"
+func QueryToken(param string) TokenExtractor {
+	return func(r *http.Request) (string, error) {
+		return singleValue(r.URL.Query()[param])
+	}
+}
+
+//FormToken returns a TokenExtractor that reads the token from the form field field of an application/x-www-form-urlencoded request body. As required by RFC 6750, GET requests and other content types are ignored.
+func FormToken(field string) TokenExtractor {
+	return func(r *http.Request) (string, error) {
+		if r.Method == http.MethodGet {
+			return "", nil
+		}
+		ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if ct != "application/x-www-form-urlencoded" {
+			return "", nil
+		}
+		if err := r.ParseForm(); err != nil {
+			return "", err
+		}
+		return singleValue(r.PostForm[field])
+	}
+}
+
+//CookieToken returns a TokenExtractor that reads the token from the cookie name
+func CookieToken(name string) TokenExtractor {
+	return func(r *http.Request) (string, error) {
+		var values []string
+		for _, c := range r.Cookies() {
+			if c.Name == name {
+				values = append(values, c.Value)
+			}
+		}
+		return singleValue(values)
+	}
+}
+
+// singleValue returns the only non-empty value of values, or ErrMultipleTokens if there are several
+func singleValue(values []string) (string, error) {
+	var token string
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		if token != "" {
+			return "", ErrMultipleTokens
+		}
+		token = v
+	}
+	return token, nil
+}
+
+//TokenOptions configures a TokenReader
+type TokenOptions struct {
+	Extractors     []TokenExtractor // tried in order, defaults to the Bearer token of the Authorization header
+	FirstMatch     bool             // use the token of the first matching extractor instead of rejecting requests that present more than one token
+	Mode           AuthMode         // how requests without a valid token are treated, defaults to AuthOptional
+	Realm          string           // realm sent in the WWW-Authenticate challenge
+	ErrorResponder ErrorResponder   // answers rejected requests, defaults to DefaultErrorResponder
+}
+
+//TokenReader extracts tokens from the locations given by its extractors, in order of precedence
+type TokenReader struct {
+	extractors []TokenExtractor
+	firstMatch bool
+	mode       AuthMode
+	realm      string
+	respond    ErrorResponder
+}
+
+var defaultTokenReader = &TokenReader{extractors: []TokenExtractor{HeaderToken("Authorization", "Bearer")}, respond: DefaultErrorResponder}
+
+//NewTokenReader returns a TokenReader using opts
+func NewTokenReader(opts TokenOptions) (*TokenReader, error) {
+	t := &TokenReader{
+		extractors: opts.Extractors,
+		firstMatch: opts.FirstMatch,
+		mode:       opts.Mode,
+		realm:      opts.Realm,
+		respond:    opts.ErrorResponder,
+	}
+	if err := t.mode.validate(); err != nil {
+		return nil, err
+	}
+	for _, e := range t.extractors {
+		if e == nil {
+			return nil, errors.New("nil token extractor")
+		}
+	}
+	if len(t.extractors) == 0 {
+		t.extractors = defaultTokenReader.extractors
+	}
+	if t.respond == nil {
+		t.respond = DefaultErrorResponder
+	}
+	return t, nil
+}
+
+//Extract returns the token of r. Unless FirstMatch is set, every extractor is consulted and ErrMultipleTokens is returned if more than one of them finds a token.
+func (t *TokenReader) Extract(r *http.Request) (string, error) {
+	var token string
+	for _, e := range t.extractors {
+		v, err := e(r)
+		if err != nil {
+			return "", err
+		}
+		if v == "" {
+			continue
+		}
+		if t.firstMatch {
+			return v, nil
+		}
+		if token != "" {
+			return "", ErrMultipleTokens
+		}
+		token = v
+	}
+	if token == "" {
+		return "", ErrTokenMissing
+	}
+	return token, nil
+}
+
+//Handler returns a http.Handler that stores the token of the request in the context, where it can be read using Token, before calling next. An unverified Principal without a subject is available through PrincipalFrom. Requests presenting more than one token are always answered with 400 Bad Request, as required by RFC 6750, other requests without a valid token are treated according to the Mode of the reader.
+func (t *TokenReader) Handler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token, err := bearer(r.Header)
+		token, err := t.Extract(r)
+		if errors.Is(err, ErrMultipleTokens) {
+			t.respond(w, r, http.StatusBadRequest, bearerChallenge(t.realm, "invalid_request", err), err)
+			return
+		}
 		if err != nil {
-			fmt.Fprintf(output, "warn: %s\n", err.Error())
-			next.ServeHTTP(w, r)
+			status, challenge := http.StatusBadRequest, bearerChallenge(t.realm, "invalid_request", err)
+			if errors.Is(err, ErrTokenMissing) {
+				status, challenge = http.StatusUnauthorized, bearerChallenge(t.realm, "", nil)
+			}
+			if !authFailure(t.mode, t.respond, w, r, status, challenge, err) {
+				next.ServeHTTP(w, r)
+			}
 			return
 		}
 		ctx := context.WithValue(r.Context(), tokenContextKey, token)
+		ctx = withPrincipal(ctx, &Identity{Method: AuthMethodTokenUnverified})
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -30,6 +203,25 @@ func Token(ctx context.Context) (token string, err error) {
 	return "", errors.New("no token found in context")
 }
 
+// requestToken returns the token found by TokenHandler or a TokenReader, or else extracts it the way TokenHandler does. Requests without a token are answered with 401 Unauthorized, and those presenting more than one token or a malformed one with 400 Bad Request, in which case ok is false.
+func requestToken(w http.ResponseWriter, r *http.Request, realm string) (token string, ok bool) {
+	if token, err := Token(r.Context()); err == nil {
+		return token, true
+	}
+	token, err := defaultTokenReader.Extract(r)
+	if errors.Is(err, ErrTokenMissing) {
+		w.Header().Set("WWW-Authenticate", bearerChallenge(realm, "", nil))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return "", false
+	}
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", bearerChallenge(realm, "invalid_request", err))
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return "", false
+	}
+	return token, true
+}
+
 func bearer(h http.Header) (token string, err error) {
 	auth := h.Get("Authorization")
 	if !strings.HasPrefix(auth, "Bearer ") {
@@ -41,3 +233,18 @@ func bearer(h http.Header) (token string, err error) {
 	}
 	return token, nil
 }
+
+// bearerChallenge returns a RFC 6750 WWW-Authenticate challenge. code and err are only included if err isn't nil.
+func bearerChallenge(realm, code string, err error) string {
+	params := []string{}
+	if realm != "" {
+		params = append(params, "realm="+quoteParam(realm))
+	}
+	if err != nil {
+		params = append(params, fmt.Sprintf("error=%q", code), "error_description="+quoteParam(err.Error()))
+	}
+	if len(params) == 0 {
+		return "Bearer"
+	}
+	return "Bearer " + strings.Join(params, ", ")
+}
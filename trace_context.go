@@ -0,0 +1,174 @@
+package middlewares
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Headers of the W3C Trace Context, https://www.w3.org/TR/trace-context/
+const (
+	headerTraceparent = "Traceparent"
+	headerTracestate  = "Tracestate"
+)
+
+const maxTracestateMembers = 32
+
+//ErrTraceparentInvalid is returned when a traceparent header can't be parsed
+var ErrTraceparentInvalid = errors.New("invalid traceparent")
+
+//TraceID identifies a trace
+type TraceID [16]byte
+
+//String returns the lowercase hex encoding of t
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+//IsValid reports whether t isn't all zeros
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+//SpanID identifies a span within a trace
+type SpanID [8]byte
+
+//String returns the lowercase hex encoding of s
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+//IsValid reports whether s isn't all zeros
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+//TraceFlags are the trace-flags of a traceparent
+type TraceFlags byte
+
+//FlagsSampled is set if the caller may have recorded the trace
+const FlagsSampled TraceFlags = 0x01
+
+//SpanContext is the part of a span that is propagated to other services
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Flags      TraceFlags
+	TraceState string // vendor specific trace information, as found in the tracestate header
+	Remote     bool   // whether the span context was propagated from another service
+}
+
+//IsValid reports whether both the trace and span ID of sc are valid
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+//IsSampled reports whether the sampled flag of sc is set
+func (sc SpanContext) IsSampled() bool {
+	return sc.Flags&FlagsSampled != 0
+}
+
+//Traceparent returns sc encoded as the value of a version 00 traceparent header
+func (sc SpanContext) Traceparent() string {
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + hex.EncodeToString([]byte{byte(sc.Flags)})
+}
+
+//ParseTraceparent parses the value of a traceparent header, e.g. "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01". Values of future versions are accepted as long as their start is formatted like version 00.
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return sc, ErrTraceparentInvalid
+	}
+	version, ok := decodeLowerHex(s[:2])
+	if !ok || version[0] == 0xff || (version[0] == 0 && len(s) != 55) || (len(s) > 55 && s[55] != '-') {
+		return sc, ErrTraceparentInvalid
+	}
+	traceID, ok1 := decodeLowerHex(s[3:35])
+	spanID, ok2 := decodeLowerHex(s[36:52])
+	flags, ok3 := decodeLowerHex(s[53:55])
+	if !ok1 || !ok2 || !ok3 {
+		return sc, ErrTraceparentInvalid
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Flags = TraceFlags(flags[0])
+	if !sc.IsValid() {
+		return sc, ErrTraceparentInvalid
+	}
+	return sc, nil
+}
+
+// decodeLowerHex decodes s, which the Trace Context requires to be lowercase
+func decodeLowerHex(s string) ([]byte, bool) {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return nil, false
+		}
+	}
+	b, err := hex.DecodeString(s)
+	return b, err == nil
+}
+
+// parseTracestate joins the tracestate header values, or returns an empty string if they are invalid
+func parseTracestate(values []string) string {
+	var members []string
+	for _, v := range values {
+		for _, m := range strings.Split(v, ",") {
+			m = strings.TrimSpace(m)
+			if m == "" {
+				continue
+			}
+			k, val, ok := strings.Cut(m, "=")
+			if !ok || k == "" || val == "" || strings.ContainsAny(k, " \t") {
+				return ""
+			}
+			members = append(members, m)
+		}
+	}
+	if len(members) > maxTracestateMembers {
+		return ""
+	}
+	return strings.Join(members, ",")
+}
+
+//ExtractTraceContext returns the remote span context propagated in the traceparent and tracestate headers of h. The tracestate is ignored if it's malformed.
+func ExtractTraceContext(h http.Header) (SpanContext, error) {
+	sc, err := ParseTraceparent(strings.TrimSpace(h.Get(headerTraceparent)))
+	if err != nil {
+		return SpanContext{}, err
+	}
+	sc.TraceState = parseTracestate(h.Values(headerTracestate))
+	sc.Remote = true
+	return sc, nil
+}
+
+//InjectTraceContext sets the traceparent and tracestate headers of h to the span context of ctx, e.g. to propagate the trace of a request to the services it calls. Nothing is set if ctx has no valid span context.
+func InjectTraceContext(ctx context.Context, h http.Header) {
+	sc := SpanContextFrom(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	h.Set(headerTraceparent, sc.Traceparent())
+	if sc.TraceState != "" {
+		h.Set(headerTracestate, sc.TraceState)
+	} else {
+		h.Del(headerTracestate)
+	}
+}
+
+//ContextWithRemoteSpanContext returns a copy of ctx holding sc as the parent of the spans started with it
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	sc.Remote = true
+	return context.WithValue(ctx, remoteSpanContextKey, sc)
+}
+
+//SpanContextFrom returns the span context of the span of ctx, or else the remote span context of ctx. The zero SpanContext is returned if there is neither.
+func SpanContextFrom(ctx context.Context) SpanContext {
+	if s, err := SpanFrom(ctx); err == nil {
+		return s.SpanContext()
+	}
+	sc, _ := ctx.Value(remoteSpanContextKey).(SpanContext)
+	return sc
+}
@@ -0,0 +1,77 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const valid = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	sc, err := ParseTraceparent(valid)
+	assert.Nil(t, err, "should be nil")
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String(), "should be equal")
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String(), "should be equal")
+	assert.True(t, sc.IsSampled(), "should be true")
+	assert.Equal(t, valid, sc.Traceparent(), "should be equal")
+
+	t.Run("Future version", func(t *testing.T) {
+		sc, err := ParseTraceparent("cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-what-the-future-holds")
+		assert.Nil(t, err, "should be nil")
+		assert.False(t, sc.IsSampled(), "should be false")
+	})
+
+	for name, s := range map[string]string{
+		"Empty":                            "",
+		"Version ff":                       "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"Version 00 too long":              valid + "-00",
+		"Future version without separator": "cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01x",
+		"Uppercase":                        strings.ToUpper(valid),
+		"Zero trace id":                    "00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"Zero span id":                     "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"Wrong separator":                  "00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"Not hex":                          "00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01",
+	} {
+		_, err := ParseTraceparent(s)
+		assert.Equal(t, ErrTraceparentInvalid, err, "should be equal: %s", name)
+	}
+}
+
+func TestTraceContextPropagation(t *testing.T) {
+	h := http.Header{}
+	h.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h.Add("tracestate", "congo=t61rcWkgMzE")
+	h.Add("tracestate", "rojo=00f067aa0ba902b7, ")
+
+	sc, err := ExtractTraceContext(h)
+	assert.Nil(t, err, "should be nil")
+	assert.True(t, sc.Remote, "should be true")
+	assert.Equal(t, "congo=t61rcWkgMzE,rojo=00f067aa0ba902b7", sc.TraceState, "should be equal")
+
+	out := http.Header{}
+	InjectTraceContext(ContextWithRemoteSpanContext(context.Background(), sc), out)
+	assert.Equal(t, h.Get("traceparent"), out.Get("traceparent"), "should be equal")
+	assert.Equal(t, sc.TraceState, out.Get("tracestate"), "should be equal")
+
+	t.Run("Malformed tracestate is dropped", func(t *testing.T) {
+		h := h.Clone()
+		h.Set("tracestate", "congo")
+		sc, err := ExtractTraceContext(h)
+		assert.Nil(t, err, "should be nil")
+		assert.Equal(t, "", sc.TraceState, "should be equal")
+
+		h.Set("tracestate", strings.Repeat("a=b,", 33))
+		sc, _ = ExtractTraceContext(h)
+		assert.Equal(t, "", sc.TraceState, "should be equal")
+	})
+
+	t.Run("Nothing to inject", func(t *testing.T) {
+		out := http.Header{}
+		InjectTraceContext(context.Background(), out)
+		assert.Len(t, out, 0, "should be empty")
+	})
+}
@@ -0,0 +1,303 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// tracerName is the name of the Tracer used by Tracing
+const tracerName = "github.com/hawry/middlewares"
+
+//SpanKind describes the relationship between a span and its parent, as in OpenTelemetry
+type SpanKind int
+
+// Kinds of spans
+const (
+	SpanKindInternal SpanKind = iota // an operation within a service, this is the default
+	SpanKindServer                   // the handling of a request from a remote client
+	SpanKindClient                   // a request to a remote service
+)
+
+//SpanStatus is the status of the operation of a span, as in OpenTelemetry
+type SpanStatus int
+
+// Span statuses
+const (
+	SpanStatusUnset SpanStatus = iota // the default status
+	SpanStatusError                   // the operation failed
+	SpanStatusOK                      // the operation was explicitly marked as successful
+)
+
+//TracerProvider returns Tracers by name, it's modelled on the TracerProvider of OpenTelemetry so that an OpenTelemetry SDK is easily adapted to it
+type TracerProvider interface {
+	Tracer(name string) Tracer
+}
+
+//Tracer starts spans
+type Tracer interface {
+	// Start starts a span that is a child of the span context of ctx, see SpanContextFrom, and returns it along with a copy of ctx holding it
+	Start(ctx context.Context, name string, kind SpanKind) (context.Context, Span)
+}
+
+//Span is a traced operation, which is exported when End is called
+type Span interface {
+	SpanContext() SpanContext
+	IsRecording() bool // whether attributes and status are recorded, false if the span isn't sampled
+	SetName(name string)
+	SetAttribute(key string, value interface{})
+	SetStatus(status SpanStatus, description string)
+	End()
+}
+
+//ContextWithSpan returns a copy of ctx holding s, Tracer implementations use it to return the spans they start
+func ContextWithSpan(ctx context.Context, s Span) context.Context {
+	return context.WithValue(ctx, spanContextKey, s)
+}
+
+//SpanFrom returns the span of ctx, if no span is found it returns an error
+func SpanFrom(ctx context.Context) (Span, error) {
+	if s, ok := ctx.Value(spanContextKey).(Span); ok {
+		return s, nil
+	}
+	return nil, errors.New("no span found in context")
+}
+
+//NoopTracerProvider returns Tracers whose spans record nothing. Their spans carry the span context of their parent, so a trace passes through a service that isn't traced.
+var NoopTracerProvider TracerProvider = noopTracerProvider{}
+
+type noopTracerProvider struct{}
+
+func (noopTracerProvider) Tracer(name string) Tracer {
+	return noopTracer{}
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, Span) {
+	s := noopSpan{sc: SpanContextFrom(ctx)}
+	return ContextWithSpan(ctx, s), s
+}
+
+type noopSpan struct {
+	sc SpanContext
+}
+
+func (s noopSpan) SpanContext() SpanContext       { return s.sc }
+func (noopSpan) IsRecording() bool                { return false }
+func (noopSpan) SetName(string)                   {}
+func (noopSpan) SetAttribute(string, interface{}) {}
+func (noopSpan) SetStatus(SpanStatus, string)     {}
+func (noopSpan) End()                             {}
+
+//SpanData is the record of an ended span passed to a SpanExporter
+type SpanData struct {
+	Name              string
+	Tracer            string // name of the Tracer that started the span
+	Kind              SpanKind
+	SpanContext       SpanContext
+	Parent            SpanContext // span context of the parent, the zero SpanContext for a root span
+	Start             time.Time
+	End               time.Time
+	Attributes        map[string]interface{}
+	Status            SpanStatus
+	StatusDescription string
+}
+
+//SpanExporter receives the spans recorded by a TracerProvider returned by NewTracerProvider
+type SpanExporter interface {
+	ExportSpan(s SpanData)
+}
+
+//NewTracerProvider returns a TracerProvider that records spans and passes them to exp when they end. A span is sampled if its parent is, spans without a parent are always sampled.
+func NewTracerProvider(exp SpanExporter) TracerProvider {
+	return &tracerProvider{exp: exp, now: time.Now}
+}
+
+type tracerProvider struct {
+	exp SpanExporter
+	now func() time.Time
+}
+
+func (p *tracerProvider) Tracer(name string) Tracer {
+	return &tracer{name: name, provider: p}
+}
+
+type tracer struct {
+	name     string
+	provider *tracerProvider
+}
+
+func (t *tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, Span) {
+	parent := SpanContextFrom(ctx)
+	sc := SpanContext{Flags: FlagsSampled}
+	if parent.IsValid() {
+		sc.TraceID, sc.Flags, sc.TraceState = parent.TraceID, parent.Flags, parent.TraceState
+	} else {
+		rand.Read(sc.TraceID[:])
+	}
+	rand.Read(sc.SpanID[:])
+
+	if !sc.IsSampled() {
+		s := noopSpan{sc: sc}
+		return ContextWithSpan(ctx, s), s
+	}
+	s := &recordingSpan{
+		provider: t.provider,
+		data: SpanData{
+			Name:        name,
+			Tracer:      t.name,
+			Kind:        kind,
+			SpanContext: sc,
+			Parent:      parent,
+			Start:       t.provider.now(),
+			Attributes:  map[string]interface{}{},
+		},
+	}
+	return ContextWithSpan(ctx, s), s
+}
+
+type recordingSpan struct {
+	provider *tracerProvider
+	mu       sync.Mutex
+	data     SpanData
+	ended    bool
+}
+
+func (s *recordingSpan) SpanContext() SpanContext {
+	return s.data.SpanContext
+}
+
+func (s *recordingSpan) IsRecording() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.ended
+}
+
+func (s *recordingSpan) SetName(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.data.Name = name
+	}
+}
+
+func (s *recordingSpan) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.data.Attributes[key] = value
+	}
+}
+
+func (s *recordingSpan) SetStatus(status SpanStatus, description string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.data.Status, s.data.StatusDescription = status, description
+	}
+}
+
+func (s *recordingSpan) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = s.provider.now()
+	data := s.data
+	s.mu.Unlock()
+	if s.provider.exp != nil {
+		s.provider.exp.ExportSpan(data)
+	}
+}
+
+//MemoryExporter is a SpanExporter that keeps the spans in memory, e.g. for tests. The zero value is ready to use.
+type MemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+//ExportSpan adds s to the spans of e
+func (e *MemoryExporter) ExportSpan(s SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, s)
+}
+
+//Spans returns the exported spans in the order they ended
+func (e *MemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]SpanData(nil), e.spans...)
+}
+
+//Reset removes all exported spans
+func (e *MemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
+
+//TracingOptions holds the settings used to build a Tracing
+type TracingOptions struct {
+	TracerProvider TracerProvider // provides the Tracer of the server spans, defaults to NoopTracerProvider
+}
+
+//Tracing starts a server span for each request, as a child of the trace propagated in its traceparent and tracestate headers. The span has the OpenTelemetry HTTP attributes http.request.method, url.path, server.address, client.address, user_agent.original and http.response.status_code, and http.route if it's set with SetRoute. Spans of requests answered with a 5xx status have the status SpanStatusError.
+type Tracing struct {
+	tracer Tracer
+}
+
+//NewTracing returns a Tracing using the settings in opts
+func NewTracing(opts TracingOptions) (*Tracing, error) {
+	tp := opts.TracerProvider
+	if tp == nil {
+		tp = NoopTracerProvider
+	}
+	return &Tracing{tracer: tp.Tracer(tracerName)}, nil
+}
+
+//Handler returns a http.Handler that serves the request within a server span. The span is available through SpanFrom, its span context is sent in the traceparent and tracestate response headers, and its trace and span IDs are included by LoggingHandler, Logger and StructuredLogger.
+func (t *Tracing) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if sc, err := ExtractTraceContext(r.Header); err == nil {
+			ctx = ContextWithRemoteSpanContext(ctx, sc)
+		}
+		ctx, span := t.tracer.Start(ctx, r.Method, SpanKindServer)
+		span.SetAttribute("http.request.method", r.Method)
+		span.SetAttribute("url.path", r.URL.Path)
+		span.SetAttribute("server.address", r.Host)
+		span.SetAttribute("client.address", ClientIP(r))
+		if ua := r.UserAgent(); ua != "" {
+			span.SetAttribute("user_agent.original", ua)
+		}
+		if info := requestInfoFrom(ctx); info != nil {
+			info.spanContext = span.SpanContext()
+		}
+		InjectTraceContext(ctx, w.Header())
+
+		rec := newResponseRecorder(w)
+		next.ServeHTTP(rec.wrap(), r.WithContext(ctx))
+		span.SetAttribute("http.response.status_code", rec.Status())
+		if rec.Status() >= http.StatusInternalServerError {
+			span.SetStatus(SpanStatusError, http.StatusText(rec.Status()))
+		}
+		span.End()
+	})
+}
+
+//SetRoute sets the route template matched by r, e.g. "/orders/{id}", on the span of r. The span is renamed to the method followed by the route, as recommended by OpenTelemetry.
+func SetRoute(r *http.Request, route string) {
+	span, err := SpanFrom(r.Context())
+	if err != nil {
+		return
+	}
+	span.SetName(r.Method + " " + route)
+	span.SetAttribute("http.route", route)
+}
@@ -0,0 +1,168 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracing(t *testing.T) {
+	exp := &MemoryExporter{}
+	tr, err := NewTracing(TracingOptions{TracerProvider: NewTracerProvider(exp)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newRequest := func(traceparent string) *http.Request {
+		req, err := http.NewRequest("GET", "/orders/42", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = "192.0.2.1:1234"
+		if traceparent != "" {
+			req.Header.Set("traceparent", traceparent)
+			req.Header.Set("tracestate", "congo=t61rcWkgMzE")
+		}
+		return req
+	}
+
+	t.Run("Continues the propagated trace", func(t *testing.T) {
+		exp.Reset()
+		var sc SpanContext
+		h := tr.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			SetRoute(r, "/orders/{id}")
+			sc = SpanContextFrom(r.Context())
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, newRequest("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"))
+
+		spans := exp.Spans()
+		if !assert.Len(t, spans, 1, "should have one span") {
+			return
+		}
+		s := spans[0]
+		assert.Equal(t, "GET /orders/{id}", s.Name, "should be equal")
+		assert.Equal(t, SpanKindServer, s.Kind, "should be equal")
+		assert.Equal(t, sc, s.SpanContext, "should be equal")
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", s.SpanContext.TraceID.String(), "should be equal")
+		assert.Equal(t, "00f067aa0ba902b7", s.Parent.SpanID.String(), "should be equal")
+		assert.True(t, s.Parent.Remote, "should be true")
+		assert.Equal(t, "congo=t61rcWkgMzE", s.SpanContext.TraceState, "should be equal")
+		assert.Equal(t, http.StatusNotFound, s.Attributes["http.response.status_code"], "should be equal")
+		assert.Equal(t, "/orders/{id}", s.Attributes["http.route"], "should be equal")
+		assert.Equal(t, "192.0.2.1", s.Attributes["client.address"], "should be equal")
+		assert.Equal(t, SpanStatusUnset, s.Status, "should be equal")
+		assert.Equal(t, sc.Traceparent(), rr.Header().Get("traceparent"), "should be equal")
+		assert.Equal(t, "congo=t61rcWkgMzE", rr.Header().Get("tracestate"), "should be equal")
+	})
+
+	t.Run("Starts a new trace", func(t *testing.T) {
+		exp.Reset()
+		h := tr.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		h.ServeHTTP(httptest.NewRecorder(), newRequest("00-4bf92f3577b34da6a3ce929d0e0e4736-invalid"))
+
+		spans := exp.Spans()
+		if !assert.Len(t, spans, 1, "should have one span") {
+			return
+		}
+		assert.True(t, spans[0].SpanContext.IsValid(), "should be true")
+		assert.False(t, spans[0].Parent.IsValid(), "should be false")
+		assert.Equal(t, "GET", spans[0].Name, "should be equal")
+		assert.Equal(t, SpanStatusError, spans[0].Status, "should be equal")
+	})
+
+	t.Run("Unsampled parent", func(t *testing.T) {
+		exp.Reset()
+		var sc SpanContext
+		h := tr.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sc = SpanContextFrom(r.Context())
+		}))
+		h.ServeHTTP(httptest.NewRecorder(), newRequest("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"))
+		assert.Len(t, exp.Spans(), 0, "should be empty")
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String(), "should be equal")
+		assert.False(t, sc.IsSampled(), "should be false")
+	})
+
+	t.Run("Child spans", func(t *testing.T) {
+		exp.Reset()
+		h := tr.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, span := NewTracerProvider(exp).Tracer("db").Start(r.Context(), "query", SpanKindClient)
+			span.End()
+		}))
+		h.ServeHTTP(httptest.NewRecorder(), newRequest(""))
+		spans := exp.Spans()
+		if !assert.Len(t, spans, 2, "should have two spans") {
+			return
+		}
+		assert.Equal(t, spans[1].SpanContext, spans[0].Parent, "should be equal")
+		assert.Equal(t, spans[1].SpanContext.TraceID, spans[0].SpanContext.TraceID, "should be equal")
+	})
+
+	t.Run("Noop tracer provider", func(t *testing.T) {
+		tr, err := NewTracing(TracingOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		tr.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			span, err := SpanFrom(r.Context())
+			assert.Nil(t, err, "should be nil")
+			assert.False(t, span.IsRecording(), "should be false")
+		})).ServeHTTP(rr, newRequest("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"))
+		assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", rr.Header().Get("traceparent"), "should be equal")
+	})
+
+	t.Run("Used by the access log", func(t *testing.T) {
+		var b bytes.Buffer
+		l, err := NewLogger(LoggerOptions{Formatter: JSONLogFormat, Output: &b})
+		if err != nil {
+			t.Fatal(err)
+		}
+		noop := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+		for name, h := range map[string]http.Handler{
+			"Tracing outside": tr.Handler(l.Handler(noop)),
+			"Tracing inside":  l.Handler(tr.Handler(noop)),
+		} {
+			exp.Reset()
+			b.Reset()
+			h.ServeHTTP(httptest.NewRecorder(), newRequest(""))
+			var line map[string]interface{}
+			assert.Nil(t, json.Unmarshal(b.Bytes(), &line), "should be nil: %s", name)
+			if spans := exp.Spans(); assert.Len(t, spans, 1, "should have one span: %s", name) {
+				assert.Equal(t, spans[0].SpanContext.TraceID.String(), line["trace_id"], "should be equal: %s", name)
+				assert.Equal(t, spans[0].SpanContext.SpanID.String(), line["span_id"], "should be equal: %s", name)
+			}
+		}
+	})
+}
+
+func TestSpanFrom(t *testing.T) {
+	_, err := SpanFrom(context.Background())
+	assert.NotNil(t, err, "should not be nil")
+	assert.False(t, SpanContextFrom(context.Background()).IsValid(), "should be false")
+}
+
+func ExampleTracing() {
+	exp := &MemoryExporter{}
+	tr, err := NewTracing(TracingOptions{TracerProvider: NewTracerProvider(exp)})
+	if err != nil {
+		// error handling
+	}
+	defaultHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetRoute(r, "/orders/{id}")
+
+		// propagate the trace to the services called
+		req, _ := http.NewRequestWithContext(r.Context(), "GET", "http://inventory/items", nil)
+		InjectTraceContext(r.Context(), req.Header)
+	})
+
+	http.Handle("/orders/", LoggingHandler(tr.Handler(defaultHandler)))
+	http.ListenAndServe(":3000", nil)
+}
@@ -0,0 +1,86 @@
+package middlewares
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// fileCheckInterval is the shortest time between two checks of a watchedFile for changes
+const fileCheckInterval = time.Second
+
+// watchedFile holds the parsed contents of a file, which is reloaded when its modification time or size changes
+type watchedFile struct {
+	path  string
+	parse func(r io.Reader) (interface{}, error)
+
+	mu        sync.RWMutex
+	value     interface{}
+	modTime   time.Time
+	size      int64
+	lastCheck time.Time
+}
+
+// newWatchedFile returns a watchedFile holding the contents of path parsed with parse, or an error if the file can't be read or parsed
+func newWatchedFile(path string, parse func(r io.Reader) (interface{}, error)) (*watchedFile, error) {
+	w := &watchedFile{path: path, parse: parse}
+	if err := w.reload(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// load returns the parsed contents of the file, after reloading it if it has changed since it was last read. Failed reloads keep the previous contents.
+func (w *watchedFile) load() interface{} {
+	w.refresh()
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.value
+}
+
+func (w *watchedFile) refresh() {
+	w.mu.RLock()
+	recent := time.Since(w.lastCheck) < fileCheckInterval
+	w.mu.RUnlock()
+	if recent {
+		return
+	}
+
+	fi, err := os.Stat(w.path)
+	w.mu.Lock()
+	w.lastCheck = time.Now()
+	changed := err == nil && (!fi.ModTime().Equal(w.modTime) || fi.Size() != w.size)
+	w.mu.Unlock()
+	if !changed {
+		return
+	}
+	if err := w.reload(); err != nil {
+		fmt.Fprintf(output, "warn: %s\n", err.Error())
+	}
+}
+
+func (w *watchedFile) reload() error {
+	f, err := os.Open(w.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	v, err := w.parse(f)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	w.value = v
+	w.modTime = fi.ModTime()
+	w.size = fi.Size()
+	w.lastCheck = time.Now()
+	w.mu.Unlock()
+	return nil
+}